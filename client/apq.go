@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Just4Ease/graphrpc/internal/cache"
+	"github.com/Just4Ease/graphrpc/manifest"
+)
+
+const persistedQueryNotFound = "PERSISTED_QUERY_NOT_FOUND"
+
+// EnableAutomaticPersistedQueries makes the client send the SHA-256 hash of a document instead of the document itself,
+// only sending the document when the remote service does not know the hash yet. The remote service must have
+// automatic persisted queries enabled, see server.EnableAutomaticPersistedQueries.
+func EnableAutomaticPersistedQueries() Option {
+	return func(o *Options) error {
+		o.persistedQueries = true
+		return nil
+	}
+}
+
+var queryHashes = cache.NewLRU(documentCacheSize)
+
+// QueryHash returns the hex encoded SHA-256 hash identifying a document as a persisted query.
+func QueryHash(query string) string {
+	if hash, ok := queryHashes.Get(query); ok {
+		return string(hash)
+	}
+
+	hash := manifest.Hash(query)
+	queryHashes.Set(query, []byte(hash), documentCacheTTL, nil)
+	return hash
+}
+
+// execPersisted sends the hash of r's document first, then the document along with its hash if the remote service
+// does not know it.
+func (c *Client) execPersisted(ctx context.Context, r *Request, headers Header) ([]byte, int, error) {
+	query := r.Query
+	persisted := &Request{
+		Variables:     r.Variables,
+		OperationName: r.OperationName,
+		Extensions: map[string]interface{}{
+			"persistedQuery": map[string]interface{}{
+				"version":    1,
+				"sha256Hash": QueryHash(query),
+			},
+		},
+	}
+
+	body, status, err := c.dispatch(ctx, persisted, query, headers)
+	if err != nil || !isPersistedQueryNotFound(body) {
+		return body, status, err
+	}
+
+	persisted.Query = query
+	return c.dispatch(ctx, persisted, query, headers)
+}
+
+func isPersistedQueryNotFound(body []byte) bool {
+	res := &struct {
+		Errors []struct {
+			Extensions struct {
+				Code string `json:"code"`
+			} `json:"extensions"`
+		} `json:"errors"`
+	}{}
+
+	if err := json.Unmarshal(body, res); err != nil {
+		return false
+	}
+
+	for _, e := range res.Errors {
+		if e.Extensions.Code == persistedQueryNotFound {
+			return true
+		}
+	}
+	return false
+}
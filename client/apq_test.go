@@ -0,0 +1,31 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryHash(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "ecf4edb46db40b5132295c0291d62fb65d6759a9eedfa4d5d612dd5ec54a6b38", QueryHash("{__typename}"))
+}
+
+func TestQueryHashesAreBounded(t *testing.T) {
+	t.Parallel()
+
+	for i := 0; i < documentCacheSize+10; i++ {
+		QueryHash(fmt.Sprintf(`{ user(id: %d) { id } }`, i))
+	}
+	require.LessOrEqual(t, queryHashes.Len(), documentCacheSize)
+}
+
+func TestIsPersistedQueryNotFound(t *testing.T) {
+	t.Parallel()
+
+	require.True(t, isPersistedQueryNotFound([]byte(`{"errors":[{"message":"PersistedQueryNotFound","extensions":{"code":"PERSISTED_QUERY_NOT_FOUND"}}]}`)))
+	require.False(t, isPersistedQueryNotFound([]byte(`{"errors":[{"message":"boom"}]}`)))
+	require.False(t, isPersistedQueryNotFound([]byte(`{"data":{"user":null}}`)))
+}
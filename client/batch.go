@@ -0,0 +1,387 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Just4Ease/axon/v2/options"
+	"github.com/Just4Ease/axon/v2/utils"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnableBatching collects the calls made within window that share the same headers and sends them to the remote
+// service as a single NATS message, which the remote service answers with one result per call. A batch is sent early
+// once it holds maxSize calls, which may not exceed the 100 operations a remote service accepts by default. Every call
+// still goes through the interceptors, retries and circuit breaker on its own, only the round trip is shared.
+// Mutations are always sent on their own, as servers only deduplicate them by idempotency key outside of batches.
+func EnableBatching(window time.Duration, maxSize int) Option {
+	return func(o *Options) error {
+		if window <= 0 {
+			return errors.New("batch window must be greater than zero")
+		}
+
+		if maxSize < 2 {
+			return errors.New("batch size must be at least 2")
+		}
+
+		if maxSize > protocol.DefaultMaxBatchSize {
+			return errors.Errorf("batch size must not exceed %d, the batch size remote services accept", protocol.DefaultMaxBatchSize)
+		}
+
+		o.batchWindow = window
+		o.batchSize = maxSize
+		return nil
+	}
+}
+
+// batchCall is a single call of a batch along with its outcome.
+type batchCall struct {
+	ctx     context.Context
+	request *Request
+	query   string
+	body    []byte
+	status  int
+	err     error
+	done    chan struct{}
+}
+
+func newBatchCall(ctx context.Context, r *Request, query string) *batchCall {
+	return &batchCall{ctx: ctx, request: r, query: query, done: make(chan struct{})}
+}
+
+// sendBatch sends calls to the remote service in a single message and records the outcome of each of them.
+func (c *Client) sendBatch(ctx context.Context, calls []*batchCall, headers Header) {
+	defer func() {
+		for _, call := range calls {
+			close(call.done)
+		}
+	}()
+
+	requests := make([]json.RawMessage, len(calls))
+	for i, call := range calls {
+		b, err := json.Marshal(call.request)
+		if err != nil {
+			failBatch(calls, fmt.Errorf("encode: %w", err))
+			return
+		}
+		requests[i] = b
+	}
+
+	requestBody, err := json.Marshal(requests)
+	if err != nil {
+		failBatch(calls, fmt.Errorf("encode: %w", err))
+		return
+	}
+
+	requestID := utils.GenerateRandomString()
+	pubHeaders := c.publishHeaders(ctx, headers)
+	pubHeaders[protocol.HeaderRequestID] = requestID
+
+	ctx, span := c.startBatchSpan(ctx, calls)
+	c.injectTraceContext(ctx, pubHeaders)
+
+	if err := c.setTimeoutHeader(ctx, pubHeaders); err != nil {
+		endSpan(span, nil, err)
+		failBatch(calls, err)
+		return
+	}
+
+	start := time.Now()
+	results := make([]protocol.BatchResult, 0, len(calls))
+	mg, err := c.axonConn.Request(c.BaseURL, requestBody, options.SetPubHeaders(pubHeaders), options.SetPubContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			go c.cancelRemote(requestID)
+		}
+		err = c.requestError(ctx, err)
+	} else if body, _, replyErr := c.decodeReply(mg); replyErr != nil {
+		err = replyErr
+	} else if decodeErr := json.Unmarshal(body, &results); decodeErr != nil {
+		err = fmt.Errorf("failed to decode batch reply %s: %w", string(body), decodeErr)
+	} else if len(results) != len(calls) {
+		err = errors.Errorf("batch reply holds %d results for %d calls", len(results), len(calls))
+	}
+	endSpan(span, nil, err)
+
+	for i, call := range calls {
+		if err != nil {
+			call.err = err
+		} else {
+			call.body, call.status = results[i].Body, results[i].Status
+		}
+
+		c.observe(call.request.OperationName, call.query, start, len(requests[i]), call.body, call.err)
+	}
+}
+
+func failBatch(calls []*batchCall, err error) {
+	for _, call := range calls {
+		call.err = err
+	}
+}
+
+// batcher groups the calls made within a window by their headers.
+type batcher struct {
+	client  *Client
+	window  time.Duration
+	maxSize int
+	mu      sync.Mutex
+	pending map[string]*pendingBatch
+}
+
+type pendingBatch struct {
+	headers Header
+	calls   []*batchCall
+}
+
+func newBatcher(c *Client, window time.Duration, maxSize int) *batcher {
+	return &batcher{client: c, window: window, maxSize: maxSize, pending: make(map[string]*pendingBatch)}
+}
+
+// do adds a call to the pending batch of calls sharing its headers and waits for its outcome.
+func (b *batcher) do(ctx context.Context, r *Request, query string, headers Header) ([]byte, int, error) {
+	headers = b.client.publishHeaders(ctx, headers)
+	key := headersKey(headers)
+	call := newBatchCall(ctx, r, query)
+
+	b.mu.Lock()
+	batch, ok := b.pending[key]
+	if !ok {
+		batch = &pendingBatch{headers: headers}
+		b.pending[key] = batch
+		time.AfterFunc(b.window, func() { b.flush(key, batch) })
+	}
+	batch.calls = append(batch.calls, call)
+	full := len(batch.calls) >= b.maxSize
+	b.mu.Unlock()
+
+	if full {
+		go b.flush(key, batch)
+	}
+
+	select {
+	case <-call.done:
+		return call.body, call.status, call.err
+	case <-ctx.Done():
+		return nil, 0, b.client.requestError(ctx, ctx.Err())
+	}
+}
+
+// flush sends batch, unless it has already been sent.
+func (b *batcher) flush(key string, batch *pendingBatch) {
+	b.mu.Lock()
+	if b.pending[key] != batch {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.pending, key)
+	b.mu.Unlock()
+
+	// A lone call is sent as is, keeping its own span and cancellation.
+	if len(batch.calls) == 1 {
+		call := batch.calls[0]
+		call.body, call.status, call.err = b.client.send(call.ctx, call.request, call.query, batch.headers)
+		close(call.done)
+		return
+	}
+
+	ctx, cancel := batchContext(batch.calls)
+	defer cancel()
+	b.client.sendBatch(ctx, batch.calls, batch.headers)
+}
+
+// batchContext returns the context a batch is sent with, which lasts as long as the most patient of its callers.
+func batchContext(calls []*batchCall) (context.Context, context.CancelFunc) {
+	var latest time.Time
+	for _, call := range calls {
+		deadline, ok := call.ctx.Deadline()
+		if !ok {
+			return context.WithCancel(context.Background())
+		}
+
+		if deadline.After(latest) {
+			latest = deadline
+		}
+	}
+
+	return context.WithDeadline(context.Background(), latest)
+}
+
+func headersKey(headers Header) string {
+	keys := make([]string, 0, len(headers))
+	for k, v := range headers {
+		keys = append(keys, k+"="+v)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "&")
+}
+
+// Batch collects operations sent to the remote service in a single message by Exec. Like Client.Exec, every operation
+// of a batch goes through the interceptors, but it skips the retries and circuit breaker, and its mutations carry no
+// idempotency key.
+type Batch struct {
+	client   *Client
+	requests []*Request
+	results  []*BatchResult
+}
+
+// BatchResult is the outcome of one operation of a Batch.
+type BatchResult struct {
+	respData interface{}
+	err      error
+}
+
+// Err returns the error of the operation, which is nil when its data was decoded into the response object. Like
+// Client.Exec, partial data is decoded alongside graphql errors unless the client was created with AllOrNothing.
+func (r *BatchResult) Err() error {
+	return r.err
+}
+
+// NewBatch returns an empty batch of operations for the remote service.
+func (c *Client) NewBatch() *Batch {
+	return &Batch{client: c}
+}
+
+// Add queues an operation whose data is decoded into respData once the batch is executed.
+func (b *Batch) Add(operationName, query string, respData interface{}, vars map[string]interface{}) *BatchResult {
+	result := &BatchResult{respData: respData}
+
+	b.requests = append(b.requests, &Request{Query: query, Variables: vars, OperationName: operationName})
+	b.results = append(b.results, result)
+	return result
+}
+
+// Exec sends the operations of the batch and decodes the result of each of them. Every operation goes through the
+// interceptors on its own, and the operations they leave with the same headers share a message. The returned error is
+// only set when the batch could not be delivered, in which case it is the error of the operations it held too.
+func (b *Batch) Exec(ctx context.Context, headers Header) error {
+	if len(b.requests) == 0 {
+		return nil
+	}
+
+	round := newBatchRound(ctx, b.client, len(b.requests))
+	invoke := chainInterceptors(b.client.opts.interceptors, round.invoke)
+
+	operations := make([]*batchOperation, len(b.requests))
+	wg := sync.WaitGroup{}
+	for i, r := range b.requests {
+		operations[i] = &batchOperation{}
+		wg.Add(1)
+		go func(op *batchOperation, r *Request, result *BatchResult) {
+			defer wg.Done()
+			result.err = invoke(context.WithValue(ctx, batchOperationKey{}, op), r.OperationName, r.Query, result.respData, r.Variables, callHeaders(headers))
+			round.leave(op)
+		}(operations[i], r, b.results[i])
+	}
+	wg.Wait()
+
+	var batchErr error
+	for i, op := range operations {
+		if op.err != nil {
+			batchErr = b.results[i].err
+		}
+	}
+
+	return batchErr
+}
+
+// batchOperationKey is the context key of the batchOperation an invocation belongs to.
+type batchOperationKey struct{}
+
+// batchOperation tracks one operation of a Batch on its way through the interceptors.
+type batchOperation struct {
+	arrived bool
+	err     error
+}
+
+// batchRound gathers the operations of a Batch as they leave the interceptors and sends them once all of them did.
+type batchRound struct {
+	ctx     context.Context
+	client  *Client
+	mu      sync.Mutex
+	waiting int
+	groups  map[string]*pendingBatch
+	order   []*pendingBatch
+}
+
+func newBatchRound(ctx context.Context, c *Client, size int) *batchRound {
+	return &batchRound{ctx: ctx, client: c, waiting: size, groups: make(map[string]*pendingBatch)}
+}
+
+// invoke is the Invoker at the end of the interceptor chain of each operation. Operations invoked again, e.g. by an
+// interceptor that retries them, are sent on their own.
+func (r *batchRound) invoke(ctx context.Context, operationName, query string, respData interface{}, vars map[string]interface{}, headers Header) error {
+	op, _ := ctx.Value(batchOperationKey{}).(*batchOperation)
+	call := newBatchCall(ctx, &Request{Query: query, Variables: vars, OperationName: operationName}, query)
+
+	r.mu.Lock()
+	if op == nil || op.arrived {
+		r.mu.Unlock()
+		call.body, call.status, call.err = r.client.send(ctx, call.request, query, headers)
+	} else {
+		key := headersKey(headers)
+		batch, ok := r.groups[key]
+		if !ok {
+			batch = &pendingBatch{headers: headers}
+			r.groups[key] = batch
+			r.order = append(r.order, batch)
+		}
+		batch.calls = append(batch.calls, call)
+		r.arrive(op)
+		r.mu.Unlock()
+		<-call.done
+	}
+
+	if call.err != nil {
+		if op != nil {
+			op.err = call.err
+		}
+		return fmt.Errorf("request failed: %w", call.err)
+	}
+
+	if r.client.opts.allOrNothing {
+		return parseResponse(call.body, call.status, respData, false)
+	}
+	return parsePartialResponse(call.body, call.status, respData, false)
+}
+
+// leave accounts for an operation whose interceptors returned without invoking it.
+func (r *batchRound) leave(op *batchOperation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !op.arrived {
+		r.arrive(op)
+	}
+}
+
+// arrive marks op as done with the interceptors and sends the batch once no operation is left in them. The caller
+// must hold r.mu.
+func (r *batchRound) arrive(op *batchOperation) {
+	op.arrived = true
+	r.waiting--
+	if r.waiting > 0 {
+		return
+	}
+
+	for _, batch := range r.order {
+		go r.send(batch)
+	}
+}
+
+func (r *batchRound) send(batch *pendingBatch) {
+	// A lone call is sent as is, keeping its own span and cancellation.
+	if len(batch.calls) == 1 {
+		call := batch.calls[0]
+		call.body, call.status, call.err = r.client.send(call.ctx, call.request, call.query, batch.headers)
+		close(call.done)
+		return
+	}
+
+	r.client.sendBatch(r.ctx, batch.calls, batch.headers)
+}
@@ -0,0 +1,261 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the remote service while its circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	return [...]string{"closed", "open", "half-open"}[s]
+}
+
+// CircuitBreakerConfig configures the circuit breakers kept per remote service and operation name.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the circuit.
+	FailureThreshold int
+	// OpenTimeout is how long the circuit stays open before letting probe calls through.
+	OpenTimeout time.Duration
+	// HalfOpenMaxCalls is the number of concurrent probe calls allowed while half-open.
+	HalfOpenMaxCalls int
+	// SuccessThreshold is the number of successful probe calls that closes the circuit again.
+	SuccessThreshold int
+	// IsFailure decides whether an error counts against the circuit. Defaults to IsRetryable,
+	// so graphql errors such as failed validations never open it.
+	IsFailure func(err error) bool
+	// OnStateChange is called whenever the circuit of a service operation changes state. Calls for an operation
+	// are made one at a time and in the order of the changes, by the caller whose call triggered the change.
+	OnStateChange func(serviceName, operationName string, from, to CircuitState)
+}
+
+// DefaultCircuitBreakerConfig opens after 5 consecutive failures and probes again after 10 seconds.
+func DefaultCircuitBreakerConfig() *CircuitBreakerConfig {
+	return &CircuitBreakerConfig{
+		FailureThreshold: 5,
+		OpenTimeout:      10 * time.Second,
+		HalfOpenMaxCalls: 1,
+		SuccessThreshold: 1,
+		IsFailure:        IsRetryable,
+	}
+}
+
+// SetCircuitBreaker enables a circuit breaker per remote service and operation name.
+func SetCircuitBreaker(cfg *CircuitBreakerConfig) Option {
+	return func(o *Options) error {
+		if cfg == nil || cfg.FailureThreshold < 1 || cfg.OpenTimeout <= 0 {
+			return errors.New("circuit breaker needs a failure threshold and an open timeout")
+		}
+
+		if cfg.HalfOpenMaxCalls < 1 {
+			cfg.HalfOpenMaxCalls = 1
+		}
+
+		if cfg.SuccessThreshold < 1 {
+			cfg.SuccessThreshold = 1
+		}
+
+		o.circuitBreaker = cfg
+		return nil
+	}
+}
+
+type circuitBreaker struct {
+	cfg           *CircuitBreakerConfig
+	serviceName   string
+	operationName string
+
+	mu        sync.Mutex
+	state     CircuitState
+	failures  int
+	successes int
+	probes    int
+	openedAt  time.Time
+	// generation counts the state changes, telling the calls admitted before the last one apart.
+	generation int
+	changes    []stateChange
+
+	// notifyMu keeps the OnStateChange calls in the order of the changes.
+	notifyMu sync.Mutex
+}
+
+type stateChange struct {
+	from, to CircuitState
+}
+
+// circuitCall is a call let through by a circuit breaker, a probe when admitted while half-open.
+type circuitCall struct {
+	generation int // generation of the breaker when the call was admitted
+}
+
+// allow reports whether a call may go through, moving an open circuit to half-open once its timeout elapsed.
+// The returned call must be handed to record once it is over.
+func (b *circuitBreaker) allow() (circuitCall, bool) {
+	defer b.notify()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.cfg.OpenTimeout {
+			return circuitCall{}, false
+		}
+		b.setState(CircuitHalfOpen)
+		fallthrough
+	case CircuitHalfOpen:
+		if b.probes >= b.cfg.HalfOpenMaxCalls {
+			return circuitCall{}, false
+		}
+		b.probes++
+		return circuitCall{generation: b.generation}, true
+	default:
+		return circuitCall{generation: b.generation}, true
+	}
+}
+
+// record accounts for the outcome of call, made with ctx. Calls ended by their caller, whether cancelled or out of
+// time, say nothing about the health of the remote service and count neither as failures nor as successes. Neither do
+// calls admitted before the circuit last changed state, as they tell about the remote service as it was then.
+func (b *circuitBreaker) record(ctx context.Context, call circuitCall, err error) {
+	defer b.notify()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if call.generation != b.generation {
+		return
+	}
+
+	failed := err != nil && b.isFailure(err)
+	abandoned := err != nil && ctx.Err() != nil
+
+	switch b.state {
+	case CircuitHalfOpen:
+		b.probes--
+		if abandoned {
+			return
+		}
+
+		if failed {
+			b.open()
+			return
+		}
+
+		b.successes++
+		if b.successes >= b.cfg.SuccessThreshold {
+			b.setState(CircuitClosed)
+		}
+	case CircuitClosed:
+		if abandoned {
+			return
+		}
+
+		if !failed {
+			b.failures = 0
+			return
+		}
+
+		b.failures++
+		if b.failures >= b.cfg.FailureThreshold {
+			b.open()
+		}
+	}
+}
+
+func (b *circuitBreaker) isFailure(err error) bool {
+	if b.cfg.IsFailure != nil {
+		return b.cfg.IsFailure(err)
+	}
+	return IsRetryable(err)
+}
+
+func (b *circuitBreaker) open() {
+	b.openedAt = time.Now()
+	b.setState(CircuitOpen)
+}
+
+func (b *circuitBreaker) setState(state CircuitState) {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.failures = 0
+	b.successes = 0
+	b.probes = 0
+
+	if from != state && b.cfg.OnStateChange != nil {
+		b.changes = append(b.changes, stateChange{from: from, to: state})
+	}
+}
+
+// notify hands the pending state changes to OnStateChange. It must be called without holding mu, so the callback
+// is free to call the client again.
+func (b *circuitBreaker) notify() {
+	if b.cfg.OnStateChange == nil {
+		return
+	}
+
+	b.notifyMu.Lock()
+	defer b.notifyMu.Unlock()
+
+	b.mu.Lock()
+	changes := b.changes
+	b.changes = nil
+	b.mu.Unlock()
+
+	for _, change := range changes {
+		b.cfg.OnStateChange(b.serviceName, b.operationName, change.from, change.to)
+	}
+}
+
+// circuitBreakers holds the circuit breaker of every operation called on the remote service.
+type circuitBreakers struct {
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+func (c *Client) circuitBreaker(operationName string) *circuitBreaker {
+	c.breakers.mu.Lock()
+	defer c.breakers.mu.Unlock()
+
+	if b, ok := c.breakers.breakers[operationName]; ok {
+		return b
+	}
+
+	b := &circuitBreaker{
+		cfg:           c.opts.circuitBreaker,
+		serviceName:   c.opts.remoteServiceName,
+		operationName: operationName,
+	}
+	c.breakers.breakers[operationName] = b
+	return b
+}
+
+// execOnce makes a single attempt at a call, guarded by the operation's circuit breaker when one is configured.
+func (c *Client) execOnce(ctx context.Context, operationName, query string, vars map[string]interface{}, headers Header) ([]byte, int, error) {
+	if c.opts.circuitBreaker == nil {
+		return c.exec(ctx, operationName, query, vars, headers)
+	}
+
+	b := c.circuitBreaker(operationName)
+	call, ok := b.allow()
+	if !ok {
+		return nil, 0, fmt.Errorf("%s %s: %w", c.opts.remoteServiceName, operationName, ErrCircuitOpen)
+	}
+
+	body, status, err := c.exec(ctx, operationName, query, vars, headers)
+	b.record(ctx, call, err)
+	return body, status, err
+}
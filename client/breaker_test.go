@@ -0,0 +1,160 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// admit lets a call through b, failing the test if b rejects it.
+func admit(t *testing.T, b *circuitBreaker) circuitCall {
+	call, ok := b.allow()
+	require.True(t, ok, "the circuit breaker rejected the call")
+	return call
+}
+
+// allowed reports whether b lets a call through.
+func allowed(b *circuitBreaker) bool {
+	_, ok := b.allow()
+	return ok
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	transitions := make(chan CircuitState, 10)
+	b := &circuitBreaker{
+		cfg: &CircuitBreakerConfig{
+			FailureThreshold: 2,
+			OpenTimeout:      20 * time.Millisecond,
+			HalfOpenMaxCalls: 1,
+			SuccessThreshold: 1,
+			OnStateChange: func(_, _ string, _, to CircuitState) {
+				transitions <- to
+			},
+		},
+	}
+
+	// graphql errors never count against the circuit
+	b.record(ctx, admit(t, b), errors.New("validation failed"))
+	b.record(ctx, admit(t, b), &NoRespondersError{})
+	require.Equal(t, CircuitClosed, b.state)
+
+	b.record(ctx, admit(t, b), &NoRespondersError{})
+	require.Equal(t, CircuitOpen, b.state)
+	require.False(t, allowed(b))
+	require.Len(t, transitions, 1, "state changes are reported before the call returns")
+	require.Equal(t, CircuitOpen, <-transitions)
+
+	time.Sleep(30 * time.Millisecond)
+	probe := admit(t, b)
+	require.Equal(t, CircuitHalfOpen, b.state)
+	require.False(t, allowed(b), "only one probe is allowed while half-open")
+	require.Equal(t, CircuitHalfOpen, <-transitions)
+
+	b.record(ctx, probe, &TimeoutError{})
+	require.Equal(t, CircuitOpen, b.state)
+	require.Equal(t, CircuitOpen, <-transitions)
+
+	time.Sleep(30 * time.Millisecond)
+	b.record(ctx, admit(t, b), nil)
+	require.Equal(t, CircuitClosed, b.state)
+}
+
+func TestCircuitBreakerIgnoresAbandonedCalls(t *testing.T) {
+	t.Parallel()
+	b := &circuitBreaker{cfg: &CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: 20 * time.Millisecond, HalfOpenMaxCalls: 1, SuccessThreshold: 1}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+
+	// The caller ran out of time, the remote service did not fail.
+	b.record(ctx, admit(t, b), &TimeoutError{})
+	require.Equal(t, CircuitClosed, b.state)
+
+	b.record(context.Background(), admit(t, b), &TimeoutError{})
+	require.Equal(t, CircuitOpen, b.state)
+
+	// An abandoned probe neither closes nor reopens the circuit, and frees its slot.
+	time.Sleep(30 * time.Millisecond)
+	b.record(ctx, admit(t, b), &TimeoutError{})
+	require.Equal(t, CircuitHalfOpen, b.state)
+	admit(t, b)
+}
+
+func TestCircuitBreakerOnlyCountsProbes(t *testing.T) {
+	t.Parallel()
+	b := &circuitBreaker{cfg: &CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: 20 * time.Millisecond, HalfOpenMaxCalls: 1, SuccessThreshold: 1}}
+
+	// Calls admitted while closed are still running when another one opens the circuit.
+	stale := []circuitCall{admit(t, b), admit(t, b)}
+	b.record(context.Background(), admit(t, b), &TimeoutError{})
+	require.Equal(t, CircuitOpen, b.state)
+
+	time.Sleep(30 * time.Millisecond)
+	probe := admit(t, b)
+	require.Equal(t, CircuitHalfOpen, b.state)
+
+	// They neither free probe slots nor decide the fate of the circuit.
+	b.record(context.Background(), stale[0], nil)
+	b.record(context.Background(), stale[1], nil)
+	require.Equal(t, CircuitHalfOpen, b.state)
+	require.False(t, allowed(b), "only one probe is allowed while half-open")
+
+	b.record(context.Background(), probe, nil)
+	require.Equal(t, CircuitClosed, b.state)
+}
+
+func TestCircuitBreakerStateChangeOrder(t *testing.T) {
+	t.Parallel()
+	var (
+		mu      sync.Mutex
+		changes [][2]CircuitState
+	)
+	b := &circuitBreaker{
+		cfg: &CircuitBreakerConfig{
+			FailureThreshold: 1,
+			OpenTimeout:      time.Nanosecond,
+			HalfOpenMaxCalls: 1,
+			SuccessThreshold: 1,
+			OnStateChange: func(_, _ string, from, to CircuitState) {
+				mu.Lock()
+				changes = append(changes, [2]CircuitState{from, to})
+				mu.Unlock()
+			},
+		},
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				call, ok := b.allow()
+				if !ok {
+					continue
+				}
+
+				var err error
+				if (i+j)%2 == 0 {
+					err = &NoRespondersError{}
+				}
+				b.record(context.Background(), call, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	require.NotEmpty(t, changes)
+	require.Equal(t, CircuitClosed, changes[0][0])
+	for i := 1; i < len(changes); i++ {
+		require.Equal(t, changes[i-1][1], changes[i][0], "change %d was reported out of order", i)
+	}
+	require.Equal(t, b.state, changes[len(changes)-1][1])
+}
@@ -0,0 +1,189 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Just4Ease/graphrpc/internal/cache"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/pkg/errors"
+	"github.com/vektah/gqlparser/v2/ast"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Setting HeaderCacheControl to CacheControlNoCache on a call makes the remote service execute the query instead of
+// serving it from its own response cache.
+const (
+	HeaderCacheControl  = protocol.HeaderCacheControl
+	CacheControlNoCache = protocol.CacheControlNoCache
+)
+
+// ResponseCache stores the replies of queries. Implementations, e.g. backed by Redis to share the cache across
+// replicas, must be safe for concurrent use; failures are treated as cache misses.
+type ResponseCache interface {
+	// Get returns the reply stored under key, unless it expired.
+	Get(ctx context.Context, key string) ([]byte, bool)
+	// Set stores a reply under key for ttl, along with the tags it can be invalidated by.
+	Set(ctx context.Context, key string, body []byte, ttl time.Duration, tags []string)
+	// Invalidate drops every reply stored with one of the tags.
+	Invalidate(ctx context.Context, tags []string)
+}
+
+// cachePolicy is how the replies of an operation are cached, or which tags a mutation invalidates.
+type cachePolicy struct {
+	ttl  time.Duration
+	tags []string
+}
+
+// EnableResponseCache caches the replies of queries in cache, keyed by the hash of their document, their variables
+// and headers. A reply is cached for the TTL set by CacheOperation, else for the maxAge of the @cacheControl hint the
+// remote service returns; replies carrying errors are never cached. Mutations skip the cache, see InvalidateOnMutation.
+// An in-memory LRU cache of 1000 replies is used when cache is nil.
+func EnableResponseCache(cache ResponseCache) Option {
+	return func(o *Options) error {
+		if cache == nil {
+			cache = NewLRUResponseCache(defaultResponseCacheSize)
+		}
+
+		o.responseCache = cache
+		return nil
+	}
+}
+
+// CacheOperation caches the replies of the named query for ttl, overriding the hint of the remote service, and tags
+// them so mutations can invalidate them. A zero ttl keeps the hint of the remote service and only sets the tags.
+func CacheOperation(operationName string, ttl time.Duration, tags ...string) Option {
+	return func(o *Options) error {
+		if strings.TrimSpace(operationName) == "" {
+			return errors.New("operation name is required")
+		}
+
+		if ttl < 0 {
+			return errors.New("cache ttl must not be negative")
+		}
+
+		if o.cachePolicies == nil {
+			o.cachePolicies = make(map[string]cachePolicy)
+		}
+		o.cachePolicies[operationName] = cachePolicy{ttl: ttl, tags: tags}
+		return nil
+	}
+}
+
+// InvalidateOnMutation drops the cached replies tagged with any of tags once the named mutation succeeds.
+func InvalidateOnMutation(mutationName string, tags ...string) Option {
+	return func(o *Options) error {
+		if strings.TrimSpace(mutationName) == "" {
+			return errors.New("mutation name is required")
+		}
+
+		if len(tags) == 0 {
+			return errors.New("at least one tag is required")
+		}
+
+		if o.invalidations == nil {
+			o.invalidations = make(map[string][]string)
+		}
+		o.invalidations[mutationName] = append(o.invalidations[mutationName], tags...)
+		return nil
+	}
+}
+
+// cached serves queries from the response cache when possible and lets mutations invalidate it.
+func (c *Client) cached(ctx context.Context, operationName, query string, vars map[string]interface{}, headers Header) ([]byte, int, error) {
+	responseCache := c.opts.responseCache
+	if responseCache == nil {
+		return c.coalesce(ctx, operationName, query, vars, headers)
+	}
+
+	switch operationType(operationName, query) {
+	case ast.Query:
+		key, err := c.responseCacheKey(operationName, query, vars, headers)
+		if err != nil {
+			return c.coalesce(ctx, operationName, query, vars, headers)
+		}
+
+		if body, ok := responseCache.Get(ctx, key); ok {
+			return body, http.StatusOK, nil
+		}
+
+		body, status, err := c.coalesce(ctx, operationName, query, vars, headers)
+		if err != nil || status != http.StatusOK || hasGraphErrors(body) {
+			return body, status, err
+		}
+
+		policy := c.opts.cachePolicies[operationName]
+		ttl := policy.ttl
+		if ttl == 0 {
+			ttl = cacheHintTTL(body)
+		}
+
+		if ttl > 0 {
+			responseCache.Set(ctx, key, body, ttl, policy.tags)
+		}
+		return body, status, nil
+	case ast.Mutation:
+		body, status, err := c.coalesce(ctx, operationName, query, vars, headers)
+		if tags := c.opts.invalidations[operationName]; len(tags) != 0 && err == nil && !hasGraphErrors(body) {
+			responseCache.Invalidate(ctx, tags)
+		}
+		return body, status, err
+	default:
+		return c.coalesce(ctx, operationName, query, vars, headers)
+	}
+}
+
+func (c *Client) responseCacheKey(operationName, query string, vars map[string]interface{}, headers Header) (string, error) {
+	b, err := json.Marshal(vars)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join([]string{c.opts.remoteServiceName, operationName, QueryHash(query), string(b), headersKey(headers)}, "\x00"), nil
+}
+
+// cacheHintTTL returns the maxAge of the @cacheControl hint in the extensions of a reply, or 0 without a hint.
+func cacheHintTTL(body []byte) time.Duration {
+	res := &struct {
+		Extensions struct {
+			CacheControl struct {
+				MaxAge int `json:"maxAge"`
+			} `json:"cacheControl"`
+		} `json:"extensions"`
+	}{}
+
+	if err := json.Unmarshal(body, res); err != nil {
+		return 0
+	}
+
+	return time.Duration(res.Extensions.CacheControl.MaxAge) * time.Second
+}
+
+const defaultResponseCacheSize = 1000
+
+// lruResponseCache is an in-memory ResponseCache evicting the least recently used replies past its size.
+type lruResponseCache struct {
+	lru *cache.LRU
+}
+
+// NewLRUResponseCache returns an in-memory ResponseCache holding up to size replies.
+func NewLRUResponseCache(size int) ResponseCache {
+	if size <= 0 {
+		size = defaultResponseCacheSize
+	}
+
+	return lruResponseCache{lru: cache.NewLRU(size)}
+}
+
+func (l lruResponseCache) Get(_ context.Context, key string) ([]byte, bool) {
+	return l.lru.Get(key)
+}
+
+func (l lruResponseCache) Set(_ context.Context, key string, body []byte, ttl time.Duration, tags []string) {
+	l.lru.Set(key, body, ttl, tags)
+}
+
+func (l lruResponseCache) Invalidate(_ context.Context, tags []string) {
+	l.lru.Invalidate(tags)
+}
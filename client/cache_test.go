@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Just4Ease/axon/v2"
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/axon/v2/options"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStore is an axon.EventStore answering every request with a user and counting the queries it receives.
+type countingStore struct {
+	axon.EventStore
+	queries int64
+}
+
+func (s *countingStore) Request(_ string, body []byte, _ ...options.PublisherOption) (*messages.Message, error) {
+	if strings.Contains(string(body), `"query":"query`) {
+		atomic.AddInt64(&s.queries, 1)
+	}
+	return messages.NewMessage().WithType(messages.ResponseMessage).WithBody([]byte(`{"data":{"user":{"id":"1"}}}`)), nil
+}
+
+func TestLRUResponseCache(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cache := NewLRUResponseCache(2)
+	cache.Set(ctx, "a", []byte("a"), time.Minute, []string{"users"})
+	cache.Set(ctx, "b", []byte("b"), time.Minute, nil)
+	cache.Set(ctx, "expired", []byte("expired"), -time.Second, nil)
+
+	// The least recently used entry was evicted to make room for the expired one.
+	_, ok := cache.Get(ctx, "a")
+	require.False(t, ok)
+	_, ok = cache.Get(ctx, "expired")
+	require.False(t, ok)
+
+	body, ok := cache.Get(ctx, "b")
+	require.True(t, ok)
+	require.Equal(t, "b", string(body))
+
+	cache.Set(ctx, "c", []byte("c"), time.Minute, []string{"users"})
+	cache.Invalidate(ctx, []string{"users"})
+	_, ok = cache.Get(ctx, "c")
+	require.False(t, ok)
+	_, ok = cache.Get(ctx, "b")
+	require.True(t, ok)
+}
+
+func TestResponseCache(t *testing.T) {
+	t.Parallel()
+
+	store := &countingStore{}
+	c, err := NewClient(store, SetRemoteServiceName("ms-users"),
+		EnableResponseCache(nil),
+		CacheOperation("GetUser", time.Minute, "users"),
+		InvalidateOnMutation("UpdateUser", "users"),
+	)
+	require.NoError(t, err)
+
+	getUser := func(id string) {
+		res := &struct{ User struct{ ID string } }{}
+		require.NoError(t, c.Exec(context.Background(), "GetUser", `query GetUser($id: ID!) { user(id: $id) { id } }`, res, map[string]interface{}{"id": id}, nil))
+		require.Equal(t, "1", res.User.ID)
+	}
+
+	getUser("1")
+	getUser("1")
+	require.Equal(t, int64(1), store.queries)
+
+	// Other variables are another entry.
+	getUser("2")
+	require.Equal(t, int64(2), store.queries)
+
+	require.NoError(t, c.Exec(context.Background(), "UpdateUser", `mutation UpdateUser { user { id } }`, &struct{ User struct{ ID string } }{}, nil, nil))
+	getUser("1")
+	require.Equal(t, int64(3), store.queries)
+}
+
+func TestCacheHintTTL(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, time.Minute, cacheHintTTL([]byte(`{"data":{},"extensions":{"cacheControl":{"maxAge":60}}}`)))
+	require.Zero(t, cacheHintTTL([]byte(`{"data":{}}`)))
+}
@@ -5,19 +5,46 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/Just4Ease/axon/v2"
-	"github.com/Just4Ease/axon/v2/messages"
 	"github.com/Just4Ease/axon/v2/options"
+	"github.com/Just4Ease/axon/v2/utils"
+	"github.com/Just4Ease/graphrpc/internal/metrics"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
 	"github.com/Yamashou/gqlgenc/graphqljson"
+	"github.com/nats-io/nats.go"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vektah/gqlparser/v2/ast"
 	"github.com/vektah/gqlparser/v2/gqlerror"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"log"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Options struct {
 	Headers               Header
 	remoteGraphEntrypoint string
 	remoteServiceName     string
+	allOrNothing          bool
+	retryPolicy           *RetryPolicy
+	idempotentOperations  map[string]bool
+	circuitBreaker        *CircuitBreakerConfig
+	interceptors          []Interceptor
+	metricsRegisterer     prometheus.Registerer
+	tracerProvider        trace.TracerProvider
+	propagator            propagation.TextMapPropagator
+	priority              string
+	persistedQueries      bool
+	batchWindow           time.Duration
+	batchSize             int
+	coalescing            bool
+	coalescingHeaders     []string
+	responseCache         ResponseCache
+	cachePolicies         map[string]cachePolicy
+	invalidations         map[string][]string
+	natsConn              *nats.Conn
 }
 
 type Option func(*Options) error
@@ -62,12 +89,26 @@ func SetRemoteServiceName(remoteServiceName string) Option {
 	}
 }
 
+// AllOrNothing makes Exec discard the data of a response that carries graphql errors, instead of returning it alongside them.
+func AllOrNothing() Option {
+	return func(o *Options) error {
+		o.allOrNothing = true
+		return nil
+	}
+}
+
 type Header = map[string]string
 
 // Client is the http client wrapper
 type Client struct {
 	axonConn axon.EventStore
 	opts     *Options
+	breakers *circuitBreakers
+	invoke   Invoker
+	metrics  *metrics.RPC
+	tracer   trace.Tracer
+	batcher  *batcher
+	flights  *flightGroup
 	BaseURL  string
 	Headers  Header
 }
@@ -77,6 +118,7 @@ type Request struct {
 	Query         string                 `json:"query"`
 	Variables     map[string]interface{} `json:"variables,omitempty"`
 	OperationName string                 `json:"operationName,omitempty"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
 }
 
 // NewClient creates a new http client wrapper
@@ -103,36 +145,145 @@ func NewClient(conn axon.EventStore, options ...Option) (*Client, error) {
 		panic("axon must not be nil. see github.com/Just4Ease/axon for more details on how to connect")
 	}
 
-	return &Client{
+	c := &Client{
 		axonConn: conn,
 		BaseURL:  fmt.Sprintf("%s.%s", opts.remoteServiceName, opts.remoteGraphEntrypoint),
 		opts:     opts,
+		breakers: &circuitBreakers{breakers: make(map[string]*circuitBreaker)},
 		Headers:  opts.Headers,
-	}, nil
+	}
+	c.invoke = chainInterceptors(opts.interceptors, c.invokeRemote)
+
+	if opts.batchWindow > 0 {
+		c.batcher = newBatcher(c, opts.batchWindow, opts.batchSize)
+	}
+
+	if opts.coalescing {
+		c.flights = &flightGroup{flights: make(map[string]*flight)}
+	}
+
+	if opts.tracerProvider != nil {
+		c.tracer = opts.tracerProvider.Tracer(tracerName)
+	}
+
+	if opts.metricsRegisterer != nil {
+		var err error
+		if c.metrics, err = metrics.NewRPC("client", opts.metricsRegisterer); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
 }
 
-func (c *Client) exec(_ context.Context, operationName, query string, variables map[string]interface{}, headers Header) ([]byte, error) {
+func (c *Client) exec(ctx context.Context, operationName, query string, variables map[string]interface{}, headers Header) ([]byte, int, error) {
 	r := &Request{
 		Query:         query,
 		Variables:     variables,
 		OperationName: operationName,
 	}
 
+	if c.opts.persistedQueries {
+		return c.execPersisted(ctx, r, headers)
+	}
+
+	return c.dispatch(ctx, r, query, headers)
+}
+
+// dispatch sends a request on its own, or along with the other calls of its batch when batching is enabled.
+// Mutations are never batched, so servers can deduplicate them by idempotency key.
+func (c *Client) dispatch(ctx context.Context, r *Request, query string, headers Header) ([]byte, int, error) {
+	if c.batcher != nil && operationType(r.OperationName, query) != ast.Mutation {
+		return c.batcher.do(ctx, r, query, headers)
+	}
+
+	return c.send(ctx, r, query, headers)
+}
+
+// send publishes a request to the remote service. query is the document of the operation, even when r only carries its hash.
+func (c *Client) send(ctx context.Context, r *Request, query string, headers Header) ([]byte, int, error) {
+	operationName := r.OperationName
 	requestBody, err := json.Marshal(r)
 	if err != nil {
-		return nil, fmt.Errorf("encode: %w", err)
+		return nil, 0, fmt.Errorf("encode: %w", err)
 	}
 
-	mg, err := c.axonConn.Request(c.BaseURL, requestBody, options.SetPubHeaders(headers))
+	requestID := utils.GenerateRandomString()
+	pubHeaders := c.publishHeaders(ctx, headers)
+	pubHeaders[protocol.HeaderRequestID] = requestID
+
+	ctx, span := c.startSpan(ctx, operationName, query)
+	c.injectTraceContext(ctx, pubHeaders)
+
+	if err := c.setTimeoutHeader(ctx, pubHeaders); err != nil {
+		endSpan(span, nil, err)
+		return nil, 0, err
+	}
+
+	start := time.Now()
+	mg, err := c.axonConn.Request(c.BaseURL, requestBody, options.SetPubHeaders(pubHeaders), options.SetPubContext(ctx))
 	if err != nil {
-		return nil, err
+		if ctx.Err() != nil {
+			go c.cancelRemote(requestID)
+		}
+
+		err = c.requestError(ctx, err)
+		c.observe(operationName, query, start, len(requestBody), nil, err)
+		endSpan(span, nil, err)
+		return nil, 0, err
 	}
 
-	if mg.Type == messages.ErrorMessage {
-		return nil, errors.New(mg.Error)
+	body, status, err := c.decodeReply(mg)
+	c.observe(operationName, query, start, len(requestBody), body, err)
+	endSpan(span, body, err)
+	return body, status, err
+}
+
+// publishHeaders returns a copy of headers along with the priority of the call.
+func (c *Client) publishHeaders(ctx context.Context, headers Header) Header {
+	pubHeaders := make(Header, len(headers)+3)
+	for k, v := range headers {
+		pubHeaders[k] = v
 	}
 
-	return mg.Body, nil
+	if _, ok := pubHeaders[protocol.HeaderPriority]; !ok && c.priority(ctx) != "" {
+		pubHeaders[protocol.HeaderPriority] = c.priority(ctx)
+	}
+	return pubHeaders
+}
+
+// setTimeoutHeader passes the remaining time budget of ctx on to the remote service.
+func (c *Client) setTimeoutHeader(ctx context.Context, pubHeaders Header) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return c.requestError(ctx, context.DeadlineExceeded)
+	}
+
+	pubHeaders[protocol.HeaderTimeout] = strconv.FormatInt(remaining.Milliseconds(), 10)
+	return nil
+}
+
+// cancelRemote tells the remote service that the caller abandoned the given call. The cancellation is broadcast to
+// every replica, since the one handling the call is not known.
+func (c *Client) cancelRemote(requestID string) {
+	subject := protocol.CancelSubject(c.opts.remoteServiceName, c.opts.remoteGraphEntrypoint)
+	if err := c.axonConn.Publish(subject, []byte(requestID), options.DisablePubStreaming()); err != nil {
+		log.Printf("failed to cancel request %s: %v", requestID, err)
+	}
+}
+
+// Ping checks that the remote service answers on NATS. It does not care whether the remote service reports itself ready.
+func (c *Client) Ping(ctx context.Context) error {
+	if _, err := c.axonConn.Request(protocol.HealthSubject(c.opts.remoteServiceName), nil, options.SetPubContext(ctx)); err != nil {
+		return c.requestError(ctx, err)
+	}
+
+	return nil
 }
 
 // GqlErrorList is the struct of a standard graphql error response
@@ -156,6 +307,9 @@ type ErrorResponse struct {
 	NetworkError *HTTPError `json:"networkErrors"`
 	// populated when http status code is OK but the server returned at least one graphql error
 	GqlErrors *gqlerror.List `json:"graphqlErrors"`
+
+	// set when the response object was populated with the data that resolved despite the errors
+	partialData bool
 }
 
 // HasErrors returns true when at least one error is declared
@@ -172,10 +326,37 @@ func (er *ErrorResponse) Error() string {
 	return string(content)
 }
 
+// Unwrap exposes the graphql errors, so they can be inspected with errors.As(err, &gqlErrorList).
+func (er *ErrorResponse) Unwrap() error {
+	if er.GqlErrors == nil {
+		return nil
+	}
+
+	return &GqlErrorList{Errors: *er.GqlErrors}
+}
+
+// HasPartialData reports whether an error returned by Exec came with data that was still decoded into the response object.
+func HasPartialData(err error) bool {
+	var errResponse *ErrorResponse
+	return errors.As(err, &errResponse) && errResponse.partialData
+}
+
 // Post sends a http POST request to the graphql endpoint with the given query then unpacks
-// the response into the given object.
+// the response into the given object. When the server returns graphql errors alongside data, the data that resolved
+// is still decoded into respData unless the client was created with AllOrNothing; see HasPartialData.
 func (c *Client) Exec(ctx context.Context, operationName, query string, respData interface{}, vars map[string]interface{}, headers Header) error {
-	result, err := c.exec(ctx, operationName, query, vars, headers)
+	headers = callHeaders(headers)
+	if c.invoke == nil {
+		return c.invokeRemote(ctx, operationName, query, respData, vars, headers)
+	}
+
+	return c.invoke(ctx, operationName, query, respData, vars, headers)
+}
+
+// invokeRemote is the Invoker at the end of the interceptor chain.
+func (c *Client) invokeRemote(ctx context.Context, operationName, query string, respData interface{}, vars map[string]interface{}, headers Header) error {
+	headers = withIdempotencyKey(ctx, operationName, query, headers)
+	result, status, err := c.cached(ctx, operationName, query, vars, headers)
 	if err != nil {
 		return fmt.Errorf("request failed: %w", err)
 	}
@@ -184,14 +365,28 @@ func (c *Client) Exec(ctx context.Context, operationName, query string, respData
 	if operationName == "introspect" {
 		isIntrospection = true
 	}
-	return parseResponse(result, 200, respData, isIntrospection)
+
+	if c.opts.allOrNothing {
+		return parseResponse(result, status, respData, isIntrospection)
+	}
+	return parsePartialResponse(result, status, respData, isIntrospection)
 }
 
 func (c *Client) ServiceName() string {
 	return c.opts.remoteServiceName
 }
 
+// parseResponse unpacks the body into result, leaving result untouched when the body carries graphql errors.
 func parseResponse(body []byte, httpCode int, result interface{}, isIntrospection bool) error {
+	return parseBody(body, httpCode, result, isIntrospection, false)
+}
+
+// parsePartialResponse unpacks the body into result, including the data that resolved alongside graphql errors.
+func parsePartialResponse(body []byte, httpCode int, result interface{}, isIntrospection bool) error {
+	return parseBody(body, httpCode, result, isIntrospection, true)
+}
+
+func parseBody(body []byte, httpCode int, result interface{}, isIntrospection, partial bool) error {
 	errResponse := &ErrorResponse{}
 	isKOCode := httpCode < 200 || 299 < httpCode
 	if isKOCode {
@@ -202,9 +397,11 @@ func parseResponse(body []byte, httpCode int, result interface{}, isIntrospectio
 	}
 
 	// some servers return a graphql error with a non OK http code, try anyway to parse the body
-	if err := unmarshal(body, result, isIntrospection); err != nil {
+	hasData, err := decode(body, result, isIntrospection, partial)
+	if err != nil {
 		if gqlErr, ok := err.(*GqlErrorList); ok {
 			errResponse.GqlErrors = &gqlErr.Errors
+			errResponse.partialData = hasData
 		} else if !isKOCode { // if is KO code there is already the http error, this error should not be returned
 			return err
 		}
@@ -224,23 +421,42 @@ type response struct {
 }
 
 func unmarshal(data []byte, res interface{}, isIntrospection bool) error {
+	_, err := decode(data, res, isIntrospection, true)
+	return err
+}
+
+// decode unpacks a graphql response into res. With partial set, the data that came back alongside graphql errors is
+// decoded too, and hasData reports whether there was any.
+func decode(data []byte, res interface{}, isIntrospection, partial bool) (hasData bool, err error) {
 	resp := response{}
 	if err := json.Unmarshal(data, &resp); err != nil {
-		return fmt.Errorf("failed to decode data %s: %w", string(data), err)
+		return false, fmt.Errorf("failed to decode data %s: %w", string(data), err)
 	}
 
 	if resp.Errors != nil && len(resp.Errors) > 0 {
 		// try to parse standard graphql error
 		errors := &GqlErrorList{}
 		if e := json.Unmarshal(data, errors); e != nil {
-			return fmt.Errorf("faild to parse graphql errors. Response content %s - %w ", string(data), e)
+			return false, fmt.Errorf("faild to parse graphql errors. Response content %s - %w ", string(data), e)
+		}
+
+		if !partial || len(resp.Data) == 0 || string(resp.Data) == "null" {
+			return false, errors
 		}
 
-		return errors
+		if err := decodeData(data, resp.Data, res, isIntrospection); err != nil {
+			return false, err
+		}
+
+		return true, errors
 	}
 
+	return false, decodeData(data, resp.Data, res, isIntrospection)
+}
+
+func decodeData(data []byte, respData json.RawMessage, res interface{}, isIntrospection bool) error {
 	if !isIntrospection {
-		if err := graphqljson.UnmarshalData(resp.Data, res); err != nil {
+		if err := graphqljson.UnmarshalData(respData, res); err != nil {
 			return fmt.Errorf("failed to decode data into response %s: %w", string(data), err)
 		}
 
@@ -248,7 +464,7 @@ func unmarshal(data []byte, res interface{}, isIntrospection bool) error {
 
 	}
 
-	if err := json.Unmarshal(resp.Data, res); err != nil {
+	if err := json.Unmarshal(respData, res); err != nil {
 		return fmt.Errorf("failed to decode data into response %s: %w", string(data), err)
 	}
 
@@ -111,6 +111,7 @@ func TestUnmarshal(t *testing.T) {
 		_ = json.Unmarshal([]byte(`["query GetUser","viewer","repositories","nsodes"]`), &path)
 		r := &fakeRes{}
 		err := unmarshal([]byte(gqlDataAndErr), r, true)
+		require.Equal(t, &fakeRes{Something: "some data"}, r)
 		expectedErr := &GqlErrorList{
 			Errors: gqlerror.List{{
 				Message: "Field 'nsodes' doesn't exist on type 'RepositoryConnection'",
@@ -224,4 +225,27 @@ func TestParseResponse(t *testing.T) {
 
 		require.Nil(t, err)
 	})
+
+	t.Run("data and error", func(t *testing.T) {
+		t.Parallel()
+		r := &fakeRes{}
+		err := parseResponse([]byte(gqlDataAndErr), 200, r, true)
+
+		require.IsType(t, &ErrorResponse{}, err)
+		require.False(t, HasPartialData(err))
+		require.Equal(t, &fakeRes{}, r)
+	})
+
+	t.Run("partial data and error", func(t *testing.T) {
+		t.Parallel()
+		r := &fakeRes{}
+		err := parsePartialResponse([]byte(gqlDataAndErr), 200, r, true)
+
+		require.True(t, HasPartialData(err))
+		require.Equal(t, &fakeRes{Something: "some data"}, r)
+
+		var gqlErr *GqlErrorList
+		require.True(t, errors.As(err, &gqlErr))
+		require.Len(t, gqlErr.Errors, 1)
+	})
 }
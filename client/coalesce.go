@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Just4Ease/graphrpc/internal/detached"
+	"github.com/vektah/gqlparser/v2/ast"
+	"net/textproto"
+	"sync"
+)
+
+// EnableCoalescing makes concurrent identical queries share a single call to the remote service: while a query is in
+// flight, the same operation with the same variables and headers waits for its reply instead of being sent again.
+// When keyHeaders are given, only those headers tell calls apart, e.g. "Authorization". Every call keeps waiting for
+// the reply until its own context ends; the shared call has no deadline of its own and is cancelled once every call
+// waiting for it gave up. Mutations and subscriptions are never coalesced.
+func EnableCoalescing(keyHeaders ...string) Option {
+	return func(o *Options) error {
+		o.coalescing = true
+		for _, name := range keyHeaders {
+			o.coalescingHeaders = append(o.coalescingHeaders, textproto.CanonicalMIMEHeaderKey(name))
+		}
+		return nil
+	}
+}
+
+// flight is a call in progress whose reply is shared by every identical call made meanwhile.
+type flight struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	callers int // calls waiting for the reply, guarded by the mutex of the flightGroup
+	body    []byte
+	status  int
+	err     error
+}
+
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// coalesce runs execWithRetry, sharing the call with identical queries already in flight.
+func (c *Client) coalesce(ctx context.Context, operationName, query string, vars map[string]interface{}, headers Header) ([]byte, int, error) {
+	if c.flights == nil || operationType(operationName, query) != ast.Query {
+		return c.execWithRetry(ctx, operationName, query, vars, headers)
+	}
+
+	key, err := c.flightKey(ctx, operationName, query, vars, headers)
+	if err != nil {
+		return c.execWithRetry(ctx, operationName, query, vars, headers)
+	}
+
+	c.flights.mu.Lock()
+	f, shared := c.flights.flights[key]
+	if !shared {
+		// The call is detached from the caller that started it, so the others still get a reply if it gives up.
+		flightCtx, cancel := context.WithCancel(detached.Context(ctx))
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		c.flights.flights[key] = f
+		go c.fly(flightCtx, key, f, operationName, query, vars, headers)
+	}
+	f.callers++
+	c.flights.mu.Unlock()
+
+	if shared && c.metrics != nil {
+		c.metrics.Coalesced(c.opts.remoteServiceName, operationName, string(ast.Query))
+	}
+
+	select {
+	case <-f.done:
+		return f.body, f.status, f.err
+	case <-ctx.Done():
+		c.leave(key, f)
+		return nil, 0, c.requestError(ctx, ctx.Err())
+	}
+}
+
+func (c *Client) fly(ctx context.Context, key string, f *flight, operationName, query string, vars map[string]interface{}, headers Header) {
+	defer func() {
+		c.flights.forget(key, f)
+		f.cancel()
+		close(f.done)
+	}()
+
+	f.body, f.status, f.err = c.execWithRetry(ctx, operationName, query, vars, headers)
+}
+
+// leave gives up on the reply of f for one caller, cancelling the call once nobody waits for it anymore.
+func (c *Client) leave(key string, f *flight) {
+	c.flights.mu.Lock()
+	defer c.flights.mu.Unlock()
+
+	f.callers--
+	if f.callers == 0 {
+		c.flights.remove(key, f)
+		f.cancel()
+	}
+}
+
+// forget stops identical calls from joining f.
+func (g *flightGroup) forget(key string, f *flight) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.remove(key, f)
+}
+
+func (g *flightGroup) remove(key string, f *flight) {
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+}
+
+// flightKey identifies identical calls by their operation, variables and relevant headers.
+func (c *Client) flightKey(ctx context.Context, operationName, query string, vars map[string]interface{}, headers Header) (string, error) {
+	b, err := json.Marshal(vars)
+	if err != nil {
+		return "", err
+	}
+
+	keyHeaders := c.publishHeaders(ctx, headers)
+	if len(c.opts.coalescingHeaders) != 0 {
+		keyHeaders = make(Header, len(c.opts.coalescingHeaders))
+		for k, v := range headers {
+			for _, name := range c.opts.coalescingHeaders {
+				if textproto.CanonicalMIMEHeaderKey(k) == name {
+					keyHeaders[name] = v
+				}
+			}
+		}
+	}
+
+	return operationName + "\x00" + query + "\x00" + string(b) + "\x00" + headersKey(keyHeaders), nil
+}
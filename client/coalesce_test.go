@@ -0,0 +1,174 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Just4Ease/axon/v2"
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/axon/v2/options"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingStore is an axon.EventStore that holds every request until release is closed.
+type blockingStore struct {
+	axon.EventStore
+	requests int64
+	release  chan struct{}
+}
+
+func (s *blockingStore) Request(_ string, _ []byte, _ ...options.PublisherOption) (*messages.Message, error) {
+	atomic.AddInt64(&s.requests, 1)
+	<-s.release
+	return messages.NewMessage().WithType(messages.ResponseMessage).WithBody([]byte(`{"data":{"user":{"id":"1"}}}`)), nil
+}
+
+func TestCoalescing(t *testing.T) {
+	t.Parallel()
+
+	registry := prometheus.NewRegistry()
+	store := &blockingStore{release: make(chan struct{})}
+	c, err := NewClient(store, SetRemoteServiceName("ms-users"), EnableCoalescing("Authorization"), EnableMetrics(registry))
+	require.NoError(t, err)
+
+	exec := func(wg *sync.WaitGroup, operationName, query string, vars map[string]interface{}, headers Header) {
+		defer wg.Done()
+		res := &struct{ User struct{ ID string } }{}
+		require.NoError(t, c.Exec(context.Background(), operationName, query, res, vars, headers))
+		require.Equal(t, "1", res.User.ID)
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		// Headers other than Authorization do not tell calls apart.
+		go exec(wg, "GetUser", `query GetUser($id: ID!) { user(id: $id) { id } }`, map[string]interface{}{"id": "1"}, Header{"X-Request-Source": time.Now().String()})
+		go exec(wg, "GetUser", `query GetUser($id: ID!) { user(id: $id) { id } }`, map[string]interface{}{"id": "2"}, nil)
+	}
+
+	wg.Add(2)
+	go exec(wg, "UpdateUser", `mutation UpdateUser { user { id } }`, nil, nil)
+	go exec(wg, "UpdateUser", `mutation UpdateUser { user { id } }`, nil, nil)
+
+	// One query per id and both mutations are sent, the other 8 queries wait for the replies.
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&store.requests) == 4 && coalescedCalls(t, registry) == 8
+	}, 5*time.Second, 10*time.Millisecond)
+
+	close(store.release)
+	wg.Wait()
+	require.Equal(t, int64(4), atomic.LoadInt64(&store.requests))
+}
+
+func coalescedCalls(t *testing.T, registry *prometheus.Registry) float64 {
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	total := 0.0
+	for _, family := range families {
+		if family.GetName() != "graphrpc_client_coalesced_total" {
+			continue
+		}
+
+		for _, m := range family.GetMetric() {
+			total += m.GetCounter().GetValue()
+		}
+	}
+	return total
+}
+
+// cancellableStore is an axon.EventStore that holds every request until release is closed or the request is cancelled.
+type cancellableStore struct {
+	axon.EventStore
+	requests  int64
+	cancelled int64
+	release   chan struct{}
+}
+
+func (s *cancellableStore) Request(_ string, _ []byte, opts ...options.PublisherOption) (*messages.Message, error) {
+	atomic.AddInt64(&s.requests, 1)
+	pubOpts, err := options.DefaultPublisherOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-s.release:
+		return messages.NewMessage().WithType(messages.ResponseMessage).WithBody([]byte(`{"data":{"user":{"id":"1"}}}`)), nil
+	case <-pubOpts.Context().Done():
+		atomic.AddInt64(&s.cancelled, 1)
+		return nil, pubOpts.Context().Err()
+	}
+}
+
+func (s *cancellableStore) Publish(string, []byte, ...options.PublisherOption) error {
+	return nil
+}
+
+func TestCoalescingOutlivesTheFirstCaller(t *testing.T) {
+	t.Parallel()
+
+	store := &cancellableStore{release: make(chan struct{})}
+	c, err := NewClient(store, SetRemoteServiceName("ms-users"), EnableCoalescing())
+	require.NoError(t, err)
+
+	const query = `query GetUser { user { id } }`
+
+	// The first caller gives up quickly, the second one still gets the reply of the call the first one started.
+	first, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	firstErr := make(chan error, 1)
+	go func() { firstErr <- c.Exec(first, "GetUser", query, &struct{ User struct{ ID string } }{}, nil, nil) }()
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&store.requests) == 1 }, 5*time.Second, time.Millisecond)
+
+	second := make(chan error, 1)
+	res := &struct{ User struct{ ID string } }{}
+	go func() { second <- c.Exec(context.Background(), "GetUser", query, res, nil, nil) }()
+
+	var timeoutErr *TimeoutError
+	require.ErrorAs(t, <-firstErr, &timeoutErr)
+	time.Sleep(50 * time.Millisecond)
+	require.Zero(t, atomic.LoadInt64(&store.cancelled))
+
+	close(store.release)
+	require.NoError(t, <-second)
+	require.Equal(t, "1", res.User.ID)
+	require.Equal(t, int64(1), atomic.LoadInt64(&store.requests))
+}
+
+func TestCoalescingCancelsAbandonedCalls(t *testing.T) {
+	t.Parallel()
+
+	store := &cancellableStore{release: make(chan struct{})}
+	defer close(store.release)
+	c, err := NewClient(store, SetRemoteServiceName("ms-users"), EnableCoalescing())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			errs <- c.Exec(ctx, "GetUser", `query GetUser { user { id } }`, &struct{ User struct{ ID string } }{}, nil, nil)
+		}()
+	}
+	require.Eventually(t, func() bool {
+		c.flights.mu.Lock()
+		defer c.flights.mu.Unlock()
+		for _, f := range c.flights.flights {
+			return f.callers == 3
+		}
+		return false
+	}, 5*time.Second, time.Millisecond)
+
+	// Every caller waits on its own context, and the shared call ends once all of them gave up.
+	cancel()
+	for i := 0; i < 3; i++ {
+		require.ErrorIs(t, <-errs, context.Canceled)
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&store.cancelled) == 1 }, 5*time.Second, time.Millisecond)
+	require.Equal(t, int64(1), atomic.LoadInt64(&store.requests))
+}
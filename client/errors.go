@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// TransportError is returned when the remote service could not handle the request at all,
+// e.g. because it is shutting down or failed before executing the operation.
+type TransportError struct {
+	Service    string
+	StatusCode int
+	Message    string
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("graphrpc service %s failed with status %d: %s", e.Service, e.StatusCode, e.Message)
+}
+
+// TimeoutError is returned when the request ran out of time, either while waiting for the reply or on the remote service.
+type TimeoutError struct {
+	Service string
+	Message string
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("graphrpc service %s timed out: %s", e.Service, e.Message)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// OverloadedError is returned when the remote service shed the request because it was at capacity.
+// It is retryable, see IsRetryable.
+type OverloadedError struct {
+	Service string
+	Message string
+}
+
+func (e *OverloadedError) Error() string {
+	return fmt.Sprintf("graphrpc service %s is overloaded: %s", e.Service, e.Message)
+}
+
+// RateLimitError is returned when the caller exceeded its rate limit on the remote service.
+// It is not retryable, as retrying before RetryAfter only hits the limit again.
+type RateLimitError struct {
+	Service    string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("graphrpc service %s rate limited the request: %s", e.Service, e.Message)
+}
+
+// DuplicateRequestError is returned when a call with the same idempotency key is still in flight on the remote
+// service, e.g. when retrying a mutation that timed out but is still running. It is retryable, as a later retry gets
+// the reply of the first call.
+type DuplicateRequestError struct {
+	Service string
+	Message string
+}
+
+func (e *DuplicateRequestError) Error() string {
+	return fmt.Sprintf("graphrpc service %s is still handling the same call: %s", e.Service, e.Message)
+}
+
+// NoRespondersError is returned when no replica of the remote service is listening, i.e. the service is down.
+type NoRespondersError struct {
+	Service string
+}
+
+func (e *NoRespondersError) Error() string {
+	return fmt.Sprintf("graphrpc service %s has no responders", e.Service)
+}
+
+func (e *NoRespondersError) Unwrap() error {
+	return nats.ErrNoResponders
+}
+
+// requestError classifies an error returned while sending a request over NATS.
+func (c *Client) requestError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, nats.ErrNoResponders):
+		return &NoRespondersError{Service: c.opts.remoteServiceName}
+	case errors.Is(err, nats.ErrTimeout), errors.Is(err, context.DeadlineExceeded), ctx.Err() == context.DeadlineExceeded:
+		return &TimeoutError{Service: c.opts.remoteServiceName, Message: err.Error(), Err: context.DeadlineExceeded}
+	case ctx.Err() != nil:
+		return ctx.Err()
+	default:
+		return err
+	}
+}
+
+// decodeReply returns the body of a reply along with the status produced by the remote graph handler,
+// turning structured error replies into their matching error type.
+func (c *Client) decodeReply(mg *messages.Message) ([]byte, int, error) {
+	if mg.Type == messages.ErrorMessage {
+		return nil, 0, &TransportError{Service: c.opts.remoteServiceName, StatusCode: http.StatusInternalServerError, Message: mg.Error}
+	}
+
+	if _, ok := mg.Header[protocol.HeaderError]; ok {
+		protocolErr := &protocol.Error{}
+		if err := json.Unmarshal(mg.Body, protocolErr); err != nil {
+			return nil, 0, fmt.Errorf("failed to decode error reply %s: %w", string(mg.Body), err)
+		}
+
+		return nil, protocolErr.Status, c.fromProtocolError(protocolErr)
+	}
+
+	status := http.StatusOK
+	if v, ok := mg.Header[protocol.HeaderStatus]; ok {
+		if code, err := strconv.Atoi(v); err == nil {
+			status = code
+		}
+	}
+
+	return mg.Body, status, nil
+}
+
+func (c *Client) fromProtocolError(err *protocol.Error) error {
+	switch err.Kind {
+	case protocol.ErrorKindTimeout:
+		return &TimeoutError{Service: c.opts.remoteServiceName, Message: err.Message, Err: context.DeadlineExceeded}
+	case protocol.ErrorKindOverloaded:
+		return &OverloadedError{Service: c.opts.remoteServiceName, Message: err.Message}
+	case protocol.ErrorKindRateLimited:
+		return &RateLimitError{Service: c.opts.remoteServiceName, Message: err.Message, RetryAfter: time.Duration(err.RetryAfter) * time.Millisecond}
+	case protocol.ErrorKindDuplicate:
+		return &DuplicateRequestError{Service: c.opts.remoteServiceName, Message: err.Message}
+	default:
+		return &TransportError{Service: c.opts.remoteServiceName, StatusCode: err.Status, Message: err.Message}
+	}
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeReply(t *testing.T) {
+	t.Parallel()
+	c := &Client{opts: &Options{remoteServiceName: "ms-users"}}
+
+	t.Run("status header", func(t *testing.T) {
+		t.Parallel()
+		mg := messages.NewMessage().WithType(messages.ResponseMessage).WithBody([]byte(qqlSingleErr))
+		mg.Header = map[string]string{protocol.HeaderStatus: "422"}
+
+		body, status, err := c.decodeReply(mg)
+		require.NoError(t, err)
+		require.Equal(t, 422, status)
+		require.Equal(t, qqlSingleErr, string(body))
+	})
+
+	t.Run("timeout error", func(t *testing.T) {
+		t.Parallel()
+		mg := messages.NewMessage().WithType(messages.ResponseMessage).WithBody([]byte(`{"kind":"timeout","status":504,"message":"context deadline exceeded"}`))
+		mg.Header = map[string]string{protocol.HeaderError: "true"}
+
+		_, status, err := c.decodeReply(mg)
+		require.Equal(t, 504, status)
+
+		var timeoutErr *TimeoutError
+		require.True(t, errors.As(err, &timeoutErr))
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+
+	t.Run("transport error", func(t *testing.T) {
+		t.Parallel()
+		mg := messages.NewMessage().WithType(messages.ResponseMessage).WithBody([]byte(`{"kind":"transport","status":503,"message":"server is shutting down"}`))
+		mg.Header = map[string]string{protocol.HeaderError: "true"}
+
+		_, _, err := c.decodeReply(mg)
+
+		var transportErr *TransportError
+		require.True(t, errors.As(err, &transportErr))
+		require.Equal(t, 503, transportErr.StatusCode)
+	})
+
+	t.Run("overloaded error", func(t *testing.T) {
+		t.Parallel()
+		mg := messages.NewMessage().WithType(messages.ResponseMessage).WithBody([]byte(`{"kind":"overloaded","status":503,"message":"server is overloaded"}`))
+		mg.Header = map[string]string{protocol.HeaderError: "true"}
+
+		_, _, err := c.decodeReply(mg)
+
+		var overloadedErr *OverloadedError
+		require.True(t, errors.As(err, &overloadedErr))
+		require.True(t, IsRetryable(err))
+	})
+
+	t.Run("rate limit error", func(t *testing.T) {
+		t.Parallel()
+		mg := messages.NewMessage().WithType(messages.ResponseMessage).WithBody([]byte(`{"kind":"rate_limited","status":429,"message":"rate limit exceeded","retryAfter":250}`))
+		mg.Header = map[string]string{protocol.HeaderError: "true"}
+
+		_, status, err := c.decodeReply(mg)
+		require.Equal(t, 429, status)
+
+		var rateLimitErr *RateLimitError
+		require.True(t, errors.As(err, &rateLimitErr))
+		require.Equal(t, 250*time.Millisecond, rateLimitErr.RetryAfter)
+		require.False(t, IsRetryable(err))
+	})
+
+	t.Run("axon error message", func(t *testing.T) {
+		t.Parallel()
+		mg := messages.NewMessage().WithType(messages.ErrorMessage)
+		mg.Error = "boom"
+
+		_, _, err := c.decodeReply(mg)
+
+		var transportErr *TransportError
+		require.True(t, errors.As(err, &transportErr))
+		require.Equal(t, "boom", transportErr.Message)
+	})
+}
+
+func TestRequestError(t *testing.T) {
+	t.Parallel()
+	c := &Client{opts: &Options{remoteServiceName: "ms-users"}}
+
+	err := c.requestError(context.Background(), nats.ErrNoResponders)
+	var noResponders *NoRespondersError
+	require.True(t, errors.As(err, &noResponders))
+
+	err = c.requestError(context.Background(), nats.ErrTimeout)
+	var timeoutErr *TimeoutError
+	require.True(t, errors.As(err, &timeoutErr))
+}
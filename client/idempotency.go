@@ -0,0 +1,43 @@
+package client
+
+import (
+	"context"
+	"github.com/Just4Ease/axon/v2/utils"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// HeaderIdempotencyKey is the header carrying the idempotency key of a mutation.
+const HeaderIdempotencyKey = protocol.HeaderIdempotencyKey
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey sets the idempotency key of the mutation made with the returned context, e.g. to reuse the key of
+// an incoming request so the whole chain is deduplicated. Mutations made without one get a random key.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// withIdempotencyKey returns headers along with the idempotency key of a mutation. The key is set once per call to
+// Exec, so every retry of the call carries the same key and a server with idempotency enabled executes it only once.
+func withIdempotencyKey(ctx context.Context, operationName, query string, headers Header) Header {
+	if operationType(operationName, query) != ast.Mutation {
+		return headers
+	}
+
+	if _, ok := headers[HeaderIdempotencyKey]; ok {
+		return headers
+	}
+
+	key, _ := ctx.Value(idempotencyKey{}).(string)
+	if key == "" {
+		key = utils.GenerateRandomString()
+	}
+
+	keyed := make(Header, len(headers)+1)
+	for k, v := range headers {
+		keyed[k] = v
+	}
+	keyed[HeaderIdempotencyKey] = key
+	return keyed
+}
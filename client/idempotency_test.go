@@ -0,0 +1,23 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithIdempotencyKey(t *testing.T) {
+	t.Parallel()
+
+	headers := Header{"Authorization": "token"}
+	require.Equal(t, headers, withIdempotencyKey(context.Background(), "GetUser", `query GetUser { user { id } }`, headers))
+
+	keyed := withIdempotencyKey(context.Background(), "UpdateUser", `mutation UpdateUser { user { id } }`, headers)
+	require.NotEmpty(t, keyed[HeaderIdempotencyKey])
+	require.Equal(t, "token", keyed["Authorization"])
+	require.NotContains(t, headers, HeaderIdempotencyKey)
+
+	ctx := WithIdempotencyKey(context.Background(), "order-1")
+	require.Equal(t, "order-1", withIdempotencyKey(ctx, "UpdateUser", `mutation UpdateUser { user { id } }`, nil)[HeaderIdempotencyKey])
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"github.com/pkg/errors"
+)
+
+// Invoker performs a call and unpacks its response into respData.
+type Invoker func(ctx context.Context, operationName, query string, respData interface{}, vars map[string]interface{}, headers Header) error
+
+// Interceptor sits between Exec and the call to the remote service. It may inspect or rewrite the call, e.g. to add
+// auth headers, and must call next to carry on with it. It can also record the outcome for logging or metrics.
+// headers is never nil and belongs to the call, so interceptors may change it. Interceptors also run around the start
+// of subscriptions, see Subscribe, with a nil respData.
+type Interceptor func(ctx context.Context, operationName, query string, respData interface{}, vars map[string]interface{}, headers Header, next Invoker) error
+
+// UseInterceptors registers interceptors for every call made by the client. The first interceptor is the outermost.
+func UseInterceptors(interceptors ...Interceptor) Option {
+	return func(o *Options) error {
+		for _, interceptor := range interceptors {
+			if interceptor == nil {
+				return errors.New("cannot use nil as interceptor")
+			}
+		}
+
+		o.interceptors = append(o.interceptors, interceptors...)
+		return nil
+	}
+}
+
+// callHeaders returns a copy of headers for a single call, which interceptors are free to change.
+func callHeaders(headers Header) Header {
+	copied := make(Header, len(headers))
+	for k, v := range headers {
+		copied[k] = v
+	}
+	return copied
+}
+
+// chainInterceptors wraps invoker with the given interceptors.
+func chainInterceptors(interceptors []Interceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, operationName, query string, respData interface{}, vars map[string]interface{}, headers Header) error {
+			return interceptor(ctx, operationName, query, respData, vars, headers, next)
+		}
+	}
+
+	return invoker
+}
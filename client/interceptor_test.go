@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainInterceptors(t *testing.T) {
+	t.Parallel()
+	calls := make([]string, 0)
+
+	tracer := func(name string) Interceptor {
+		return func(ctx context.Context, operationName, query string, respData interface{}, vars map[string]interface{}, headers Header, next Invoker) error {
+			calls = append(calls, name)
+			headers[name] = "true"
+			return next(ctx, operationName, query, respData, vars, headers)
+		}
+	}
+
+	var received Header
+	invoker := chainInterceptors([]Interceptor{tracer("first"), tracer("second")}, func(ctx context.Context, operationName, query string, respData interface{}, vars map[string]interface{}, headers Header) error {
+		calls = append(calls, "remote")
+		received = headers
+		return nil
+	})
+
+	require.NoError(t, invoker(context.Background(), "GetUser", "query GetUser { user { id } }", nil, nil, Header{}))
+	require.Equal(t, []string{"first", "second", "remote"}, calls)
+	require.Equal(t, Header{"first": "true", "second": "true"}, received)
+}
+
+func TestExecHandsInterceptorsTheirOwnHeaders(t *testing.T) {
+	t.Parallel()
+
+	authenticate := func(ctx context.Context, operationName, query string, respData interface{}, vars map[string]interface{}, headers Header, next Invoker) error {
+		headers["Authorization"] = "Bearer token"
+		return next(ctx, operationName, query, respData, vars, headers)
+	}
+
+	var received Header
+	c := &Client{invoke: chainInterceptors([]Interceptor{authenticate}, func(ctx context.Context, operationName, query string, respData interface{}, vars map[string]interface{}, headers Header) error {
+		received = headers
+		return nil
+	})}
+
+	require.NoError(t, c.Exec(context.Background(), "GetUser", "query GetUser { user { id } }", nil, nil, nil))
+	require.Equal(t, Header{"Authorization": "Bearer token"}, received)
+
+	shared := Header{"X-Tenant": "acme"}
+	require.NoError(t, c.Exec(context.Background(), "GetUser", "query GetUser { user { id } }", nil, nil, shared))
+	require.Equal(t, Header{"X-Tenant": "acme", "Authorization": "Bearer token"}, received)
+	require.Equal(t, Header{"X-Tenant": "acme"}, shared, "the caller's headers were changed")
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Just4Ease/graphrpc/internal/metrics"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// EnableMetrics records Prometheus metrics for every call sent to the remote service.
+// The default Prometheus registerer is used when registerer is nil.
+func EnableMetrics(registerer prometheus.Registerer) Option {
+	return func(o *Options) error {
+		if registerer == nil {
+			registerer = prometheus.DefaultRegisterer
+		}
+
+		o.metricsRegisterer = registerer
+		return nil
+	}
+}
+
+// observe records a call made by exec.
+func (c *Client) observe(operationName, query string, start time.Time, requestSize int, body []byte, err error) {
+	if c.metrics == nil {
+		return
+	}
+
+	observation := metrics.Observation{
+		Service:       c.opts.remoteServiceName,
+		Operation:     operationName,
+		OperationType: string(operationType(operationName, query)),
+		Duration:      time.Since(start),
+		RequestSize:   requestSize,
+		ResponseSize:  len(body),
+	}
+
+	if err != nil {
+		observation.ErrorKind = errorKind(err)
+	} else if hasGraphErrors(body) {
+		observation.ErrorKind = metrics.ErrorKindGraphQL
+	}
+
+	c.metrics.Observe(observation)
+}
+
+func errorKind(err error) string {
+	var timeoutErr *TimeoutError
+	var noRespondersErr *NoRespondersError
+	var overloadedErr *OverloadedError
+	var rateLimitErr *RateLimitError
+	var duplicateErr *DuplicateRequestError
+	var transportErr *TransportError
+
+	switch {
+	case errors.As(err, &timeoutErr):
+		return string(protocol.ErrorKindTimeout)
+	case errors.As(err, &overloadedErr):
+		return string(protocol.ErrorKindOverloaded)
+	case errors.As(err, &rateLimitErr):
+		return string(protocol.ErrorKindRateLimited)
+	case errors.As(err, &duplicateErr):
+		return string(protocol.ErrorKindDuplicate)
+	case errors.As(err, &noRespondersErr):
+		return "no_responders"
+	case errors.As(err, &transportErr):
+		return string(protocol.ErrorKindTransport)
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "unknown"
+	}
+}
+
+func hasGraphErrors(body []byte) bool {
+	res := &struct {
+		Errors []json.RawMessage `json:"errors"`
+	}{}
+
+	return json.Unmarshal(body, res) == nil && len(res.Errors) != 0
+}
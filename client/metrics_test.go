@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Just4Ease/graphrpc/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorKind(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "timeout", errorKind(fmt.Errorf("request failed: %w", &TimeoutError{Service: "ms-users"})))
+	require.Equal(t, "no_responders", errorKind(&NoRespondersError{Service: "ms-users"}))
+	require.Equal(t, "transport", errorKind(&TransportError{Service: "ms-users", StatusCode: 503}))
+	require.Equal(t, "canceled", errorKind(context.Canceled))
+}
+
+func TestObserve(t *testing.T) {
+	t.Parallel()
+
+	registry := prometheus.NewRegistry()
+	rpc, err := metrics.NewRPC("client", registry)
+	require.NoError(t, err)
+
+	// A second client sharing the registry reuses the collectors of the first.
+	_, err = metrics.NewRPC("client", registry)
+	require.NoError(t, err)
+
+	c := &Client{opts: &Options{remoteServiceName: "ms-users"}, metrics: rpc}
+	query := `query GetUser { user { id } }`
+	c.observe("GetUser", query, time.Now(), 42, []byte(`{"data":{"user":{"id":"1"}}}`), nil)
+	c.observe("GetUser", query, time.Now(), 42, []byte(qqlSingleErr), nil)
+	c.observe("GetUser", query, time.Now(), 42, nil, &NoRespondersError{Service: "ms-users"})
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	counts := make(map[string]float64)
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			if m.GetCounter() == nil {
+				continue
+			}
+
+			labels := ""
+			for _, label := range m.GetLabel() {
+				labels += label.GetName() + "=" + label.GetValue() + ","
+			}
+			counts[family.GetName()+"{"+labels+"}"] = m.GetCounter().GetValue()
+		}
+	}
+
+	require.Equal(t, float64(3), counts["graphrpc_client_requests_total{operation=GetUser,operation_type=query,service=ms-users,}"])
+	require.Equal(t, float64(1), counts["graphrpc_client_errors_total{kind=graphql,operation=GetUser,operation_type=query,service=ms-users,}"])
+	require.Equal(t, float64(1), counts["graphrpc_client_errors_total{kind=no_responders,operation=GetUser,operation_type=query,service=ms-users,}"])
+}
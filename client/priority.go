@@ -0,0 +1,40 @@
+package client
+
+import (
+	"context"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/pkg/errors"
+)
+
+// Priorities of calls, used by an overloaded server to decide which calls to serve first and which to shed.
+const (
+	PriorityInteractive = protocol.PriorityInteractive
+	PriorityBatch       = protocol.PriorityBatch
+)
+
+type priorityKey struct{}
+
+// SetPriority sets the priority of every call made by the client, e.g. PriorityBatch for a background worker.
+func SetPriority(priority string) Option {
+	return func(o *Options) error {
+		if priority != PriorityInteractive && priority != PriorityBatch {
+			return errors.Errorf("unknown priority %s", priority)
+		}
+
+		o.priority = priority
+		return nil
+	}
+}
+
+// WithPriority overrides the client's priority for calls made with the returned context.
+func WithPriority(ctx context.Context, priority string) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+func (c *Client) priority(ctx context.Context) string {
+	if priority, ok := ctx.Value(priorityKey{}).(string); ok {
+		return priority
+	}
+
+	return c.opts.priority
+}
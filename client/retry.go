@@ -0,0 +1,192 @@
+package client
+
+import (
+	"context"
+	"github.com/Just4Ease/graphrpc/internal/cache"
+	"github.com/pkg/errors"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how failed calls are retried. Queries are retried whenever Retryable accepts the error,
+// mutations only when they are marked idempotent with SetIdempotentOperations or MarkIdempotent.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between two attempts.
+	MaxBackoff time.Duration
+	// Multiplier grows the backoff after every attempt.
+	Multiplier float64
+	// Jitter is the fraction of the backoff, between 0 and 1, that is randomized.
+	Jitter float64
+	// Retryable decides whether an error is worth another attempt. Defaults to IsRetryable.
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy retries up to 3 attempts with an exponential backoff starting at 50ms.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 50 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		Retryable:      IsRetryable,
+	}
+}
+
+// IsRetryable reports whether err is a transient failure: no responders, a timeout, an overloaded or an unavailable
+// service, or a duplicate of a call still in flight.
+func IsRetryable(err error) bool {
+	var noResponders *NoRespondersError
+	var timeoutErr *TimeoutError
+	var overloadedErr *OverloadedError
+	var duplicateErr *DuplicateRequestError
+	var transportErr *TransportError
+
+	switch {
+	case errors.As(err, &noResponders), errors.As(err, &timeoutErr), errors.As(err, &overloadedErr), errors.As(err, &duplicateErr):
+		return true
+	case errors.As(err, &transportErr):
+		return transportErr.StatusCode == 503
+	default:
+		return false
+	}
+}
+
+// backoff returns the wait before the given retry, attempt 1 being the first retry.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		d -= d * p.Jitter * rand.Float64()
+	}
+
+	return time.Duration(d)
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// SetRetryPolicy makes the client retry transient failures according to the given policy.
+func SetRetryPolicy(policy *RetryPolicy) Option {
+	return func(o *Options) error {
+		if policy == nil || policy.MaxAttempts < 1 {
+			return errors.New("retry policy must allow at least one attempt")
+		}
+
+		o.retryPolicy = policy
+		return nil
+	}
+}
+
+// SetIdempotentOperations marks mutations that are safe to retry, by operation name.
+func SetIdempotentOperations(operationNames ...string) Option {
+	return func(o *Options) error {
+		if o.idempotentOperations == nil {
+			o.idempotentOperations = make(map[string]bool)
+		}
+
+		for _, name := range operationNames {
+			o.idempotentOperations[name] = true
+		}
+		return nil
+	}
+}
+
+type retryPolicyKey struct{}
+type idempotentKey struct{}
+
+// WithRetryPolicy overrides the client's retry policy for calls made with the returned context.
+func WithRetryPolicy(ctx context.Context, policy *RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+// MarkIdempotent marks the mutation made with the returned context as safe to retry.
+func MarkIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+func (c *Client) retryPolicy(ctx context.Context, operationName, query string) *RetryPolicy {
+	policy := c.opts.retryPolicy
+	if p, ok := ctx.Value(retryPolicyKey{}).(*RetryPolicy); ok {
+		policy = p
+	}
+
+	if policy == nil {
+		return nil
+	}
+
+	if operationType(operationName, query) != ast.Mutation {
+		return policy
+	}
+
+	if idempotent, _ := ctx.Value(idempotentKey{}).(bool); idempotent || c.opts.idempotentOperations[operationName] {
+		return policy
+	}
+
+	return nil
+}
+
+// execWithRetry runs exec, retrying transient failures according to the retry policy in effect for the call.
+func (c *Client) execWithRetry(ctx context.Context, operationName, query string, vars map[string]interface{}, headers Header) ([]byte, int, error) {
+	policy := c.retryPolicy(ctx, operationName, query)
+	if policy == nil {
+		return c.execOnce(ctx, operationName, query, vars, headers)
+	}
+
+	for attempt := 1; ; attempt++ {
+		body, status, err := c.execOnce(ctx, operationName, query, vars, headers)
+		if err == nil || attempt >= policy.MaxAttempts || !policy.retryable(err) {
+			return body, status, err
+		}
+
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return body, status, err
+		}
+	}
+}
+
+// Parsed documents are remembered for documentCacheTTL, up to documentCacheSize of them, as callers may build
+// documents on the fly.
+const (
+	documentCacheSize = 1024
+	documentCacheTTL  = time.Hour
+)
+
+var operationTypes = cache.NewLRU(documentCacheSize)
+
+// operationType returns whether the named operation of query is a query, mutation or subscription.
+func operationType(operationName, query string) ast.Operation {
+	if op, ok := operationTypes.Get(query + operationName); ok {
+		return ast.Operation(op)
+	}
+
+	op := ast.Query
+	if doc, err := parser.ParseQuery(&ast.Source{Input: query}); err == nil {
+		if def := doc.Operations.ForName(operationName); def != nil {
+			op = def.Operation
+		} else if len(doc.Operations) == 1 {
+			op = doc.Operations[0].Operation
+		}
+	}
+
+	operationTypes.Set(query+operationName, []byte(op), documentCacheTTL, nil)
+	return op
+}
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	t.Parallel()
+	p := &RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     300 * time.Millisecond,
+		Multiplier:     2,
+	}
+
+	require.Equal(t, 100*time.Millisecond, p.backoff(1))
+	require.Equal(t, 200*time.Millisecond, p.backoff(2))
+	require.Equal(t, 300*time.Millisecond, p.backoff(3))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.backoff(1)
+		require.True(t, d > 50*time.Millisecond && d <= 100*time.Millisecond, "backoff %s out of range", d)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	t.Parallel()
+	require.True(t, IsRetryable(fmt.Errorf("request failed: %w", &NoRespondersError{})))
+	require.True(t, IsRetryable(&TimeoutError{}))
+	require.True(t, IsRetryable(&OverloadedError{}))
+	require.True(t, IsRetryable(&TransportError{StatusCode: 503}))
+	require.False(t, IsRetryable(&TransportError{StatusCode: 500}))
+	require.False(t, IsRetryable(errors.New("validation failed")))
+}
+
+func TestRetryPolicyForOperation(t *testing.T) {
+	t.Parallel()
+	policy := DefaultRetryPolicy()
+	c := &Client{opts: &Options{retryPolicy: policy}}
+
+	query := `query GetUser($id: ID!) { user(id: $id) { id } }`
+	mutation := `mutation CreateUser { createUser { id } }`
+
+	require.Equal(t, ast.Mutation, operationType("CreateUser", mutation))
+	require.Equal(t, policy, c.retryPolicy(context.Background(), "GetUser", query))
+	require.Nil(t, c.retryPolicy(context.Background(), "CreateUser", mutation))
+	require.Equal(t, policy, c.retryPolicy(MarkIdempotent(context.Background()), "CreateUser", mutation))
+
+	require.NoError(t, SetIdempotentOperations("CreateUser")(c.opts))
+	require.Equal(t, policy, c.retryPolicy(context.Background(), "CreateUser", mutation))
+}
+
+func TestOperationTypesAreBounded(t *testing.T) {
+	t.Parallel()
+
+	for i := 0; i < documentCacheSize+10; i++ {
+		require.Equal(t, ast.Mutation, operationType("", fmt.Sprintf(`mutation { createUser(id: %d) { id } }`, i)))
+	}
+	require.LessOrEqual(t, operationTypes.Len(), documentCacheSize)
+}
@@ -0,0 +1,214 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Just4Ease/axon/v2/options"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"log"
+	"time"
+)
+
+// subscriptionFrameBacklog bounds the frames buffered for a subscription. The server waits for each frame to be
+// acknowledged before sending the next one, so only a few are ever pending.
+const subscriptionFrameBacklog = 16
+
+// ErrNATSConnectionRequired is returned by Subscribe when the client was not given a NATS connection.
+var ErrNATSConnectionRequired = errors.New("a NATS connection is required, see UseNATSConnection")
+
+// UseNATSConnection lets the client receive subscription frames on nc, usually the connection the axon.EventStore was
+// created with. Frames are sent to a subject of each subscription alone, which axon.EventStore cannot listen on.
+// The client neither owns nor closes nc.
+func UseNATSConnection(nc *nats.Conn) Option {
+	return func(o *Options) error {
+		if nc == nil {
+			return errors.New("cannot use nil as nats connection")
+		}
+
+		o.natsConn = nc
+		return nil
+	}
+}
+
+// SubscriptionPayload is a single result delivered on a subscription channel.
+// Err is set when the subscription failed, in which case it is the last payload delivered.
+type SubscriptionPayload struct {
+	Data []byte
+	Err  error
+}
+
+// Decode unpacks the payload into the given response object, just like Exec does for queries.
+func (p *SubscriptionPayload) Decode(respData interface{}) error {
+	if p.Err != nil {
+		return p.Err
+	}
+
+	return parseResponse(p.Data, 200, respData, false)
+}
+
+// Subscribe starts a subscription on the remote service and returns a channel receiving every result it produces.
+// The channel is closed when the subscription completes, fails or ctx is cancelled; cancelling ctx also tears down
+// the resolver on the remote service. Subscriptions need the NATS connection given to UseNATSConnection.
+// The interceptors of the client run around the start of the subscription.
+func (c *Client) Subscribe(ctx context.Context, operationName, query string, vars map[string]interface{}, headers Header) (<-chan *SubscriptionPayload, error) {
+	var ch <-chan *SubscriptionPayload
+	start := func(ctx context.Context, operationName, query string, _ interface{}, vars map[string]interface{}, headers Header) error {
+		var err error
+		ch, err = c.subscribe(ctx, operationName, query, vars, headers)
+		return err
+	}
+
+	if err := chainInterceptors(c.opts.interceptors, start)(ctx, operationName, query, nil, vars, callHeaders(headers)); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// subscribe starts a subscription on the remote service.
+func (c *Client) subscribe(ctx context.Context, operationName, query string, vars map[string]interface{}, headers Header) (<-chan *SubscriptionPayload, error) {
+	requestBody, err := json.Marshal(&Request{
+		Query:         query,
+		Variables:     vars,
+		OperationName: operationName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encode: %w", err)
+	}
+
+	nc := c.opts.natsConn
+	if nc == nil {
+		return nil, ErrNATSConnectionRequired
+	}
+
+	// Frames are sent to a subject of this subscription alone, which must be listened on before the server
+	// learns about it.
+	inbox := nats.NewInbox()
+	frames := make(chan *nats.Msg, subscriptionFrameBacklog)
+	sub, err := nc.ChanSubscribe(inbox, frames)
+	if err != nil {
+		return nil, fmt.Errorf("subscription failed: %w", err)
+	}
+
+	if err := nc.Flush(); err != nil {
+		_ = sub.Unsubscribe()
+		return nil, fmt.Errorf("subscription failed: %w", err)
+	}
+
+	subject := protocol.SubscribeSubject(c.opts.remoteServiceName, c.opts.remoteGraphEntrypoint)
+	body, err := c.sendFrame(ctx, subject, &protocol.Frame{Type: protocol.FrameStart, Subject: inbox, Payload: requestBody}, headers)
+	if err != nil {
+		_ = sub.Unsubscribe()
+		return nil, fmt.Errorf("subscription failed: %w", err)
+	}
+
+	ack := &protocol.Frame{}
+	if err := json.Unmarshal(body, ack); err != nil {
+		_ = sub.Unsubscribe()
+		return nil, fmt.Errorf("subscription failed: %w", err)
+	}
+
+	ch := make(chan *SubscriptionPayload)
+	go c.receiveSubscription(ctx, nc, sub, frames, time.Duration(ack.KeepAlive)*time.Millisecond, ch)
+	return ch, nil
+}
+
+// receiveSubscription delivers the frames of a subscription to ch, acknowledging each once it has been received.
+// The subscription fails once no frame arrived for protocol.KeepAliveWindow of keepAlive, e.g. because the replica
+// running it crashed. A zero keepAlive waits for frames forever.
+func (c *Client) receiveSubscription(ctx context.Context, nc *nats.Conn, sub *nats.Subscription, frames <-chan *nats.Msg, keepAlive time.Duration, ch chan<- *SubscriptionPayload) {
+	defer close(ch)
+	defer func() { _ = sub.Unsubscribe() }()
+
+	var timer *time.Timer
+	var expired <-chan time.Time
+	if keepAlive > 0 {
+		timer = time.NewTimer(protocol.KeepAliveWindow(keepAlive))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	deliver := func(payload *SubscriptionPayload) bool {
+		select {
+		case ch <- payload:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	stop := func() {
+		if err := nc.Publish(protocol.StopSubject(sub.Subject), nil); err != nil {
+			log.Printf("failed to stop subscription on %s: %v", sub.Subject, err)
+		}
+	}
+
+	for {
+		var msg *nats.Msg
+		select {
+		case msg = <-frames:
+		case <-expired:
+			stop()
+			deliver(&SubscriptionPayload{Err: &TimeoutError{
+				Service: c.opts.remoteServiceName,
+				Message: "no subscription frame arrived within the keep-alive window",
+			}})
+			return
+		case <-ctx.Done():
+			stop()
+			return
+		}
+
+		if timer != nil {
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(protocol.KeepAliveWindow(keepAlive))
+		}
+
+		frame := &protocol.Frame{}
+		if err := json.Unmarshal(msg.Data, frame); err != nil {
+			stop()
+			deliver(&SubscriptionPayload{Err: fmt.Errorf("failed to decode subscription frame: %w", err)})
+			return
+		}
+
+		switch frame.Type {
+		case protocol.FrameData:
+			if !deliver(&SubscriptionPayload{Data: frame.Payload}) {
+				stop()
+				return
+			}
+		case protocol.FrameError:
+			_ = msg.Respond(nil)
+			err := parseResponse(frame.Payload, 200, &struct{}{}, true)
+			if err == nil {
+				err = errors.New("subscription failed")
+			}
+			deliver(&SubscriptionPayload{Err: err})
+			return
+		case protocol.FrameComplete:
+			_ = msg.Respond(nil)
+			return
+		}
+
+		_ = msg.Respond(nil)
+	}
+}
+
+func (c *Client) sendFrame(ctx context.Context, subject string, frame *protocol.Frame, headers Header) ([]byte, error) {
+	b, err := json.Marshal(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	mg, err := c.axonConn.Request(subject, b, options.SetPubHeaders(headers), options.SetPubContext(ctx))
+	if err != nil {
+		return nil, c.requestError(ctx, err)
+	}
+
+	body, _, err := c.decodeReply(mg)
+	return body, err
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/Just4Ease/graphrpc/client"
+
+// EnableTracing starts a span for every call sent to the remote service and propagates the trace context in the
+// request headers. The global tracer provider is used when provider is nil and W3C trace context when propagator is nil.
+func EnableTracing(provider trace.TracerProvider, propagator propagation.TextMapPropagator) Option {
+	return func(o *Options) error {
+		if provider == nil {
+			provider = otel.GetTracerProvider()
+		}
+
+		if propagator == nil {
+			propagator = propagation.TraceContext{}
+		}
+
+		o.tracerProvider = provider
+		o.propagator = propagator
+		return nil
+	}
+}
+
+// startSpan starts the client span of a call, it returns ctx and a no-op span when tracing is disabled.
+func (c *Client) startSpan(ctx context.Context, operationName, query string) (context.Context, trace.Span) {
+	if c.tracer == nil {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+
+	return c.tracer.Start(ctx, fmt.Sprintf("%s/%s", c.opts.remoteServiceName, operationName),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String("graphrpc"),
+			semconv.RPCServiceKey.String(c.opts.remoteServiceName),
+			semconv.RPCMethodKey.String(operationName),
+			semconv.MessagingDestinationKey.String(c.BaseURL),
+			attribute.String("graphql.operation.name", operationName),
+			attribute.String("graphql.operation.type", string(operationType(operationName, query))),
+		),
+	)
+}
+
+// startBatchSpan starts the client span of a batch, linked to the spans of the calls it carries.
+func (c *Client) startBatchSpan(ctx context.Context, calls []*batchCall) (context.Context, trace.Span) {
+	if c.tracer == nil {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+
+	links := make([]trace.Link, len(calls))
+	for i, call := range calls {
+		links[i] = trace.LinkFromContext(call.ctx, attribute.String("graphql.operation.name", call.request.OperationName))
+	}
+
+	return c.tracer.Start(ctx, fmt.Sprintf("%s/batch", c.opts.remoteServiceName),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String("graphrpc"),
+			semconv.RPCServiceKey.String(c.opts.remoteServiceName),
+			semconv.MessagingDestinationKey.String(c.BaseURL),
+			attribute.Int("graphrpc.batch.size", len(calls)),
+		),
+	)
+}
+
+// injectTraceContext writes the trace context of ctx into the headers of an outgoing request.
+func (c *Client) injectTraceContext(ctx context.Context, headers Header) {
+	if c.opts.propagator == nil {
+		return
+	}
+
+	c.opts.propagator.Inject(ctx, propagation.MapCarrier(headers))
+}
+
+// endSpan ends the client span of a call, marking it as failed when the call failed or the reply carries graphql errors.
+func endSpan(span trace.Span, body []byte, err error) {
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case span.IsRecording() && hasGraphErrors(body):
+		span.SetStatus(codes.Error, "graphql errors")
+	}
+
+	span.End()
+}
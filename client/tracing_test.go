@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Just4Ease/axon/v2"
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/axon/v2/options"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordingStore is an axon.EventStore that answers every request with body and keeps the headers it was sent.
+type recordingStore struct {
+	axon.EventStore
+	body    []byte
+	headers map[string]string
+}
+
+func (s *recordingStore) Request(_ string, _ []byte, opts ...options.PublisherOption) (*messages.Message, error) {
+	pubOpts, err := options.DefaultPublisherOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	s.headers = pubOpts.Headers()
+	return messages.NewMessage().WithType(messages.ResponseMessage).WithBody(s.body), nil
+}
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	store := &recordingStore{body: []byte(qqlSingleErr)}
+
+	c, err := NewClient(store, SetRemoteServiceName("ms-users"), EnableTracing(provider, nil))
+	require.NoError(t, err)
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "gateway")
+	_ = c.Exec(ctx, "GetUser", `query GetUser { user { id } }`, &struct{}{}, nil, nil)
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	span := spans[0]
+	require.Equal(t, "ms-users/GetUser", span.Name())
+	require.Equal(t, trace.SpanKindClient, span.SpanKind())
+	require.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+	require.Equal(t, codes.Error, span.Status().Code)
+
+	// The remote service continues the trace from the client span.
+	remote := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier(store.headers)))
+	require.Equal(t, span.SpanContext().TraceID(), remote.TraceID())
+	require.Equal(t, span.SpanContext().SpanID(), remote.SpanID())
+}
@@ -194,7 +194,7 @@ func loadRemoteSchema(ctx context.Context, c *gencConf.Config, conn axon.EventSt
 		return nil, fmt.Errorf("introspection query failed: %w", err)
 	}
 
-	schema, err := validator.ValidateSchemaDocument(introspection.ParseIntrospectionQuery(fmt.Sprintf("graphrpc://%s.introspect", rpc.ServiceName()), res))
+	schema, err := validator.ValidateSchemaDocument(parseIntrospectionQuery(fmt.Sprintf("graphrpc://%s.introspect", rpc.ServiceName()), res))
 	if err != nil && err.Error() != "" {
 		return nil, fmt.Errorf("validation error: %w", err)
 	}
@@ -202,6 +202,23 @@ func loadRemoteSchema(ctx context.Context, c *gencConf.Config, conn axon.EventSt
 	return schema, nil
 }
 
+// parseIntrospectionQuery turns an introspection result into a schema document. gqlgenc only carries the query and
+// mutation types over to the schema definition, so the subscription type is added here for subscription operations
+// to be generated.
+func parseIntrospectionQuery(url string, res introspection.Query) *ast.SchemaDocument {
+	doc := introspection.ParseIntrospectionQuery(url, res)
+	if res.Schema.SubscriptionType == nil || res.Schema.SubscriptionType.Name == nil || len(doc.Schema) == 0 {
+		return doc
+	}
+
+	doc.Schema[0].OperationTypes = append(doc.Schema[0].OperationTypes, &ast.OperationTypeDefinition{
+		Operation: ast.Subscription,
+		Type:      *res.Schema.SubscriptionType.Name,
+		Position:  doc.Position,
+	})
+	return doc
+}
+
 func loadLocalSchema(c *gencConf.Config) (*ast.Schema, error) {
 	schema, err := gqlparser.LoadSchema(c.GQLConfig.Sources...)
 	if err != nil {
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Yamashou/gqlgenc/graphqljson"
+	"github.com/Yamashou/gqlgenc/introspection"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/validator"
+)
+
+const subscriptionIntrospection = `{
+	"__schema": {
+		"queryType": {"name": "Query"},
+		"subscriptionType": {"name": "Subscription"},
+		"types": [
+			{"kind": "OBJECT", "name": "Query", "fields": [{"name": "ping", "args": [], "type": {"kind": "NON_NULL", "ofType": {"kind": "SCALAR", "name": "String"}}}]},
+			{"kind": "OBJECT", "name": "Subscription", "fields": [{"name": "count", "args": [], "type": {"kind": "NON_NULL", "ofType": {"kind": "SCALAR", "name": "Int"}}}]},
+			{"kind": "SCALAR", "name": "String"},
+			{"kind": "SCALAR", "name": "Int"}
+		],
+		"directives": []
+	}
+}`
+
+func TestParseIntrospectionQuery(t *testing.T) {
+	var res introspection.Query
+	require.NoError(t, graphqljson.UnmarshalData(json.RawMessage(subscriptionIntrospection), &res))
+
+	schema, err := validator.ValidateSchemaDocument(parseIntrospectionQuery("graphrpc://ms-ticker.introspect", res))
+	require.Nil(t, err)
+	require.NotNil(t, schema.Subscription)
+	require.Equal(t, "Subscription", schema.Subscription.Name)
+	require.NotNil(t, schema.Subscription.Fields.ForName("count"))
+}
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	genCfg "github.com/99designs/gqlgen/codegen/config"
 	"github.com/99designs/gqlgen/plugin"
+	"github.com/Just4Ease/graphrpc/manifest"
 	"github.com/Yamashou/gqlgenc/clientgen"
 	gencConf "github.com/Yamashou/gqlgenc/config"
+	"path/filepath"
 )
 
 var _ plugin.ConfigMutator = &Plugin{}
@@ -107,5 +109,16 @@ func (p Plugin) MutateConfig(cfg *genCfg.Config) error {
 		return fmt.Errorf("template failed: %w", err)
 	}
 
+	// 4. 操作ごとのマニフェストを出力
+	// 4. Write the manifest of operations next to the generated client
+	operationManifest := manifest.New(p.remoteServiceName)
+	for _, operation := range operations {
+		operationManifest.Add(operation.Name, operation.Operation)
+	}
+
+	if err := operationManifest.Write(filepath.Join(filepath.Dir(p.Client.Filename), manifest.Filename)); err != nil {
+		return fmt.Errorf("manifest failed: %w", err)
+	}
+
 	return nil
 }
@@ -65,6 +65,7 @@ type Operation struct {
 	Operation           string
 	Args                []*Argument
 	VariableDefinitions ast.VariableDefinitionList
+	IsSubscription      bool
 }
 
 func NewOperation(operation *ast.OperationDefinition, queryDocument *ast.QueryDocument, args []*Argument, generateConfig *config.GenerateConfig) *Operation {
@@ -74,6 +75,7 @@ func NewOperation(operation *ast.OperationDefinition, queryDocument *ast.QueryDo
 		Operation:           queryString(queryDocument),
 		Args:                args,
 		VariableDefinitions: operation.VariableDefinitions,
+		IsSubscription:      operation.Operation == ast.Subscription,
 	}
 }
 
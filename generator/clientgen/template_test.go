@@ -0,0 +1,94 @@
+package clientgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/99designs/gqlgen/codegen/templates"
+	"github.com/stretchr/testify/require"
+)
+
+// renderClient executes template.gotpl the way RenderTemplate does, minus the package loading gqlgen needs to
+// resolve import names, and returns the formatted source of the generated package.
+func renderClient(t *testing.T, data map[string]interface{}) []byte {
+	imports := make([]string, 0)
+	funcs := templates.Funcs()
+	funcs["reserveImport"] = func(path string, _ ...string) (string, error) {
+		imports = append(imports, path)
+		return "", nil
+	}
+	funcs["ref"] = func(t types.Type) string {
+		return types.TypeString(t, func(p *types.Package) string { return p.Name() })
+	}
+
+	src, err := ioutil.ReadFile("template.gotpl")
+	require.NoError(t, err)
+
+	tpl, err := template.New("template.gotpl").Funcs(funcs).Parse(string(src))
+	require.NoError(t, err)
+
+	body := new(bytes.Buffer)
+	require.NoError(t, tpl.Execute(body, data))
+
+	out := new(bytes.Buffer)
+	out.WriteString("package generated\n\nimport (\n")
+	for _, importPath := range imports {
+		// Only keep the imports the generated code refers to, as gqlgen prunes the others.
+		name := path.Base(strings.TrimSuffix(importPath, "/v2"))
+		if strings.Contains(body.String(), name+".") {
+			fmt.Fprintf(out, "\t%q\n", importPath)
+		}
+	}
+	out.WriteString(")\n")
+	out.Write(body.Bytes())
+
+	formatted, err := format.Source(out.Bytes())
+	require.NoError(t, err, out.String())
+	return formatted
+}
+
+func TestRenderSubscription(t *testing.T) {
+	userType := types.NewStruct([]*types.Var{
+		types.NewField(0, nil, "ID", types.Typ[types.String], false),
+	}, []string{`json:"id" graphql:"id"`})
+	response := types.NewStruct([]*types.Var{
+		types.NewField(0, nil, "UserCreated", userType, false),
+	}, []string{`json:"userCreated" graphql:"userCreated"`})
+
+	source := renderClient(t, map[string]interface{}{
+		"Query":    &Query{Name: "Query", Type: types.NewStruct(nil, nil)},
+		"Mutation": (*Mutation)(nil),
+		"Fragment": []*Fragment{},
+		"Operation": []*Operation{{
+			Name:               "OnUserCreated",
+			ResponseStructName: "OnUserCreated",
+			Operation:          "subscription OnUserCreated { userCreated { id } }",
+			IsSubscription:     true,
+		}},
+		"OperationResponse": []*OperationResponse{{Name: "OnUserCreated", Type: response}},
+		"GenerateClient":    true,
+	})
+
+	require.Contains(t, string(source), "func (c *ServiceClient) OnUserCreated(ctx context.Context, headers client.Header) (<-chan *OnUserCreatedPayload, error)")
+	require.Contains(t, string(source), `c.client.Subscribe(ctx, "OnUserCreated", OnUserCreatedDocument, vars, headers)`)
+
+	// The generated package must build against the client package.
+	dir, err := ioutil.TempDir(".", "generated")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "generated.go"), source, 0644))
+
+	out, err := exec.Command("go", "build", "./"+dir).CombinedOutput()
+	require.NoError(t, err, "%s\n%s", out, source)
+}
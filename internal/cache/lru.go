@@ -0,0 +1,110 @@
+// Package cache holds the in-memory reply cache shared by clients and servers.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	key     string
+	body    []byte
+	expires time.Time
+	tags    []string
+}
+
+// LRU caches replies until they expire, evicting the least recently used ones past its size. It is safe for
+// concurrent use.
+type LRU struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+	tags    map[string]map[string]struct{}
+}
+
+// NewLRU returns an empty cache holding up to size replies.
+func NewLRU(size int) *LRU {
+	return &LRU{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		tags:    make(map[string]map[string]struct{}),
+	}
+}
+
+// Get returns the reply stored under key, unless it expired.
+func (l *LRU) Get(key string) ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	element, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := element.Value.(*entry)
+	if time.Now().After(e.expires) {
+		l.remove(element)
+		return nil, false
+	}
+
+	l.order.MoveToFront(element)
+	return e.body, true
+}
+
+// Set stores a reply under key for ttl, along with the tags it can be invalidated by.
+func (l *LRU) Set(key string, body []byte, ttl time.Duration, tags []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if element, ok := l.entries[key]; ok {
+		l.remove(element)
+	}
+
+	l.entries[key] = l.order.PushFront(&entry{key: key, body: body, expires: time.Now().Add(ttl), tags: tags})
+	for _, tag := range tags {
+		if l.tags[tag] == nil {
+			l.tags[tag] = make(map[string]struct{})
+		}
+		l.tags[tag][key] = struct{}{}
+	}
+
+	for l.order.Len() > l.size {
+		l.remove(l.order.Back())
+	}
+}
+
+// Len returns the number of replies held, expired ones included until they are evicted.
+func (l *LRU) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.order.Len()
+}
+
+// Invalidate drops every reply stored with one of the tags.
+func (l *LRU) Invalidate(tags []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, tag := range tags {
+		for key := range l.tags[tag] {
+			if element, ok := l.entries[key]; ok {
+				l.remove(element)
+			}
+		}
+		delete(l.tags, tag)
+	}
+}
+
+func (l *LRU) remove(element *list.Element) {
+	e := l.order.Remove(element).(*entry)
+	delete(l.entries, e.key)
+	for _, tag := range e.tags {
+		delete(l.tags[tag], e.key)
+		if len(l.tags[tag]) == 0 {
+			delete(l.tags, tag)
+		}
+	}
+}
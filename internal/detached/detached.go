@@ -0,0 +1,22 @@
+// Package detached derives contexts that outlive their parent.
+package detached
+
+import (
+	"context"
+	"time"
+)
+
+// Context returns a context carrying the values of parent but none of its deadline or cancellation, for work that
+// must go on once the caller that started it is gone.
+func Context(parent context.Context) context.Context {
+	return detachedContext{parent: parent}
+}
+
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (c detachedContext) Done() <-chan struct{}             { return nil }
+func (c detachedContext) Err() error                        { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
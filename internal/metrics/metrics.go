@@ -0,0 +1,209 @@
+package metrics
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
+)
+
+// ErrorKindGraphQL labels calls that completed but whose response carries graphql errors.
+const ErrorKindGraphQL = "graphql"
+
+// OperationOther labels the calls of operations an RPC does not keep track of, so that callers picking arbitrary
+// operation names cannot grow the number of series without bounds.
+const OperationOther = "other"
+
+// MaxOperations is the number of distinct operation names an RPC labels its metrics with, unless it was told which
+// names to expect with KnownOperations. Operations seen once the limit is reached are labelled OperationOther.
+const MaxOperations = 100
+
+var labels = []string{"service", "operation", "operation_type"}
+
+// RPC holds the collectors recording GraphRPC calls on one side of the wire, i.e. "server" or "client".
+type RPC struct {
+	requests     *prometheus.CounterVec
+	errors       *prometheus.CounterVec
+	duration     *prometheus.HistogramVec
+	requestSize  *prometheus.HistogramVec
+	responseSize *prometheus.HistogramVec
+	coalesced    *prometheus.CounterVec
+
+	mu         sync.Mutex
+	operations map[string]struct{} // operation names used as label values
+	known      bool                // operations was set by KnownOperations and does not grow
+}
+
+// Observation describes a single call.
+type Observation struct {
+	Service       string
+	Operation     string
+	OperationType string
+	Duration      time.Duration
+	RequestSize   int
+	ResponseSize  int
+	ErrorKind     string // empty when the call succeeded
+}
+
+// NewRPC registers the collectors of the given subsystem on registerer. Collectors that are already registered,
+// e.g. by another client sharing the registry, are reused.
+func NewRPC(subsystem string, registerer prometheus.Registerer) (*RPC, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	sizeBuckets := prometheus.ExponentialBuckets(64, 4, 8)
+
+	m := &RPC{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "graphrpc",
+			Subsystem: subsystem,
+			Name:      "requests_total",
+			Help:      "Number of GraphRPC calls.",
+		}, labels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "graphrpc",
+			Subsystem: subsystem,
+			Name:      "errors_total",
+			Help:      "Number of GraphRPC calls that failed, by kind of failure.",
+		}, append(labels, "kind")),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "graphrpc",
+			Subsystem: subsystem,
+			Name:      "request_duration_seconds",
+			Help:      "Duration of GraphRPC calls.",
+			Buckets:   prometheus.DefBuckets,
+		}, labels),
+		requestSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "graphrpc",
+			Subsystem: subsystem,
+			Name:      "request_size_bytes",
+			Help:      "Size of GraphRPC request payloads.",
+			Buckets:   sizeBuckets,
+		}, labels),
+		responseSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "graphrpc",
+			Subsystem: subsystem,
+			Name:      "response_size_bytes",
+			Help:      "Size of GraphRPC response payloads.",
+			Buckets:   sizeBuckets,
+		}, labels),
+		coalesced: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "graphrpc",
+			Subsystem: subsystem,
+			Name:      "coalesced_total",
+			Help:      "Number of GraphRPC calls that shared the reply of an identical call in flight instead of being sent.",
+		}, labels),
+		operations: make(map[string]struct{}),
+	}
+
+	var err error
+	if m.requests, err = registerCounter(registerer, m.requests); err != nil {
+		return nil, err
+	}
+	if m.errors, err = registerCounter(registerer, m.errors); err != nil {
+		return nil, err
+	}
+	if m.duration, err = registerHistogram(registerer, m.duration); err != nil {
+		return nil, err
+	}
+	if m.requestSize, err = registerHistogram(registerer, m.requestSize); err != nil {
+		return nil, err
+	}
+	if m.responseSize, err = registerHistogram(registerer, m.responseSize); err != nil {
+		return nil, err
+	}
+	if m.coalesced, err = registerCounter(registerer, m.coalesced); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// KnownOperations limits the operation label to names, e.g. the operations of the registered manifests.
+func (m *RPC) KnownOperations(names ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.operations = make(map[string]struct{}, len(names))
+	for _, name := range names {
+		m.operations[name] = struct{}{}
+	}
+	m.known = true
+}
+
+// Observe records a call.
+func (m *RPC) Observe(o Observation) {
+	operation, operationType := m.labelValues(o.Operation, o.OperationType)
+
+	m.requests.WithLabelValues(o.Service, operation, operationType).Inc()
+	m.duration.WithLabelValues(o.Service, operation, operationType).Observe(o.Duration.Seconds())
+	m.requestSize.WithLabelValues(o.Service, operation, operationType).Observe(float64(o.RequestSize))
+	m.responseSize.WithLabelValues(o.Service, operation, operationType).Observe(float64(o.ResponseSize))
+	if o.ErrorKind != "" {
+		m.errors.WithLabelValues(o.Service, operation, operationType, o.ErrorKind).Inc()
+	}
+}
+
+// Coalesced records a call that shared the reply of an identical call in flight.
+func (m *RPC) Coalesced(service, operation, operationType string) {
+	operation, operationType = m.labelValues(operation, operationType)
+	m.coalesced.WithLabelValues(service, operation, operationType).Inc()
+}
+
+func (m *RPC) labelValues(operation, operationType string) (string, string) {
+	if operation == "" {
+		operation = "anonymous"
+	} else {
+		operation = m.operationLabel(operation)
+	}
+
+	if operationType == "" {
+		operationType = "unknown"
+	}
+	return operation, operationType
+}
+
+func (m *RPC) operationLabel(operation string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.operations[operation]; ok {
+		return operation
+	}
+
+	if m.known || len(m.operations) >= MaxOperations {
+		return OperationOther
+	}
+
+	m.operations[operation] = struct{}{}
+	return operation
+}
+
+func registerCounter(registerer prometheus.Registerer, c *prometheus.CounterVec) (*prometheus.CounterVec, error) {
+	if err := registerer.Register(c); err != nil {
+		are := prometheus.AlreadyRegisteredError{}
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(*prometheus.CounterVec); ok {
+				return existing, nil
+			}
+		}
+		return nil, errors.Wrap(err, "failed to register metrics")
+	}
+
+	return c, nil
+}
+
+func registerHistogram(registerer prometheus.Registerer, h *prometheus.HistogramVec) (*prometheus.HistogramVec, error) {
+	if err := registerer.Register(h); err != nil {
+		are := prometheus.AlreadyRegisteredError{}
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(*prometheus.HistogramVec); ok {
+				return existing, nil
+			}
+		}
+		return nil, errors.Wrap(err, "failed to register metrics")
+	}
+
+	return h, nil
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+// requestsByOperation returns the requests_total counters of registry by operation label.
+func requestsByOperation(t *testing.T, registry *prometheus.Registry) map[string]float64 {
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	counts := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != "graphrpc_server_requests_total" {
+			continue
+		}
+
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "operation" {
+					counts[label.GetValue()] += m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return counts
+}
+
+func TestOperationLabelIsBounded(t *testing.T) {
+	t.Parallel()
+
+	registry := prometheus.NewRegistry()
+	rpc, err := NewRPC("server", registry)
+	require.NoError(t, err)
+
+	for i := 0; i < MaxOperations+50; i++ {
+		rpc.Observe(Observation{Service: "ms-users", Operation: fmt.Sprintf("Operation%d", i), OperationType: "query"})
+	}
+	rpc.Observe(Observation{Service: "ms-users", Operation: "Operation0", OperationType: "query"})
+	rpc.Observe(Observation{Service: "ms-users", OperationType: "query"})
+
+	counts := requestsByOperation(t, registry)
+	require.Len(t, counts, MaxOperations+2)
+	require.Equal(t, float64(2), counts["Operation0"])
+	require.Equal(t, float64(50), counts[OperationOther])
+	require.Equal(t, float64(1), counts["anonymous"])
+}
+
+func TestKnownOperations(t *testing.T) {
+	t.Parallel()
+
+	registry := prometheus.NewRegistry()
+	rpc, err := NewRPC("server", registry)
+	require.NoError(t, err)
+	rpc.KnownOperations("GetUser", "CreateUser")
+
+	rpc.Observe(Observation{Service: "ms-users", Operation: "GetUser", OperationType: "query"})
+	rpc.Observe(Observation{Service: "ms-users", Operation: "CreateUser", OperationType: "mutation"})
+	rpc.Observe(Observation{Service: "ms-users", Operation: "DropUsers", OperationType: "mutation"})
+
+	require.Equal(t, map[string]float64{"GetUser": 1, "CreateUser": 1, OperationOther: 1}, requestsByOperation(t, registry))
+}
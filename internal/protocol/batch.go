@@ -0,0 +1,22 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// DefaultMaxBatchSize is how many operations a batch may carry unless the server sets another limit.
+const DefaultMaxBatchSize = 100
+
+// BatchResult is the reply to one operation of a batch. A batch is a JSON array of requests sent to the graph subject,
+// answered with a JSON array of results in the same order.
+type BatchResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// IsBatch reports whether a request body holds a batch of operations rather than a single one.
+func IsBatch(body []byte) bool {
+	body = bytes.TrimSpace(body)
+	return len(body) != 0 && body[0] == '['
+}
@@ -0,0 +1,36 @@
+package protocol
+
+type ErrorKind string
+
+const (
+	// ErrorKindTransport means the remote service could not handle the request at all.
+	ErrorKindTransport ErrorKind = "transport"
+	// ErrorKindTimeout means the request ran out of time on the remote service.
+	ErrorKindTimeout ErrorKind = "timeout"
+	// ErrorKindOverloaded means the remote service shed the request because it was at capacity. It is safe to retry.
+	ErrorKindOverloaded ErrorKind = "overloaded"
+	// ErrorKindRateLimited means the caller exceeded its rate limit and should wait RetryAfter before trying again.
+	ErrorKindRateLimited ErrorKind = "rate_limited"
+	// ErrorKindDuplicate means a call with the same idempotency key is still in flight. Retrying later replays its reply.
+	ErrorKindDuplicate ErrorKind = "duplicate"
+)
+
+const (
+	// HeaderStatus carries the HTTP status code produced by the server-side graph handler.
+	HeaderStatus = "X-GraphRPC-Status"
+	// HeaderError flags a reply whose body is an Error rather than a GraphQL response.
+	HeaderError = "X-GraphRPC-Error"
+)
+
+// Error is the structured error carried in the body of replies flagged with HeaderError.
+type Error struct {
+	Kind    ErrorKind `json:"kind"`
+	Status  int       `json:"status"`
+	Message string    `json:"message"`
+	// RetryAfter is how long, in milliseconds, the caller should wait before retrying. Set on rate limited replies.
+	RetryAfter int64 `json:"retryAfter,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
@@ -0,0 +1,33 @@
+package protocol
+
+import "fmt"
+
+const (
+	// HeaderRequestID identifies a single call so it can be cancelled on the server.
+	HeaderRequestID = "X-GraphRPC-Request-Id"
+	// HeaderTimeout carries the caller's remaining time budget in milliseconds.
+	HeaderTimeout = "X-GraphRPC-Timeout"
+	// HeaderPriority tells an overloaded server which calls to serve first, see PriorityInteractive and PriorityBatch.
+	HeaderPriority = "X-GraphRPC-Priority"
+	// HeaderCacheControl set to CacheControlNoCache makes the server execute a query instead of serving it from cache.
+	HeaderCacheControl = "X-GraphRPC-Cache-Control"
+	// HeaderIdempotencyKey identifies a logical mutation call, so its retries are not executed twice.
+	HeaderIdempotencyKey = "X-GraphRPC-Idempotency-Key"
+	// HeaderIdempotentReplay flags a reply replayed from an earlier call with the same idempotency key.
+	HeaderIdempotentReplay = "X-GraphRPC-Idempotent-Replay"
+)
+
+// CacheControlNoCache bypasses the server response cache, see HeaderCacheControl.
+const CacheControlNoCache = "no-cache"
+
+const (
+	// PriorityInteractive is the priority of calls someone is waiting on. Calls without a priority are interactive.
+	PriorityInteractive = "interactive"
+	// PriorityBatch is the priority of background calls, which are queued behind and shed before interactive ones.
+	PriorityBatch = "batch"
+)
+
+// CancelSubject is the subject servers listen on for cancellation of in-flight calls.
+func CancelSubject(serviceName, graphEntrypoint string) string {
+	return fmt.Sprintf("%s.%s.cancel", serviceName, graphEntrypoint)
+}
@@ -0,0 +1,19 @@
+package protocol
+
+import "fmt"
+
+const (
+	HealthStatusOK          = "ok"
+	HealthStatusUnavailable = "unavailable"
+)
+
+// HealthReport is the reply of the health subject and the body of the readiness endpoint.
+type HealthReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// HealthSubject is the subject a service answers health probes on.
+func HealthSubject(serviceName string) string {
+	return fmt.Sprintf("%s.health", serviceName)
+}
@@ -0,0 +1,54 @@
+// Package protocol holds the wire types shared by the GraphRPC client and server.
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type FrameType string
+
+const (
+	// FrameStart asks the server to start a subscription, sending its frames to the subject of the FrameStart.
+	// The server acknowledges it with a FrameStart carrying the subscription id.
+	FrameStart FrameType = "start"
+	// FrameStop asks the server to tear down a subscription. It is published on the StopSubject of the subscription.
+	FrameStop FrameType = "stop"
+	// FrameData carries one GraphQL response produced by the subscription.
+	FrameData FrameType = "data"
+	// FrameError carries a GraphQL response describing why the subscription ended.
+	FrameError FrameType = "error"
+	// FrameComplete marks the end of a subscription.
+	FrameComplete FrameType = "complete"
+	// FrameKeepAlive is sent while a subscription has nothing to deliver, so a vanished client is noticed.
+	FrameKeepAlive FrameType = "keepalive"
+)
+
+// KeepAliveWindow is how long a client waits for a frame, keep-alives included, before giving a subscription up.
+// Servers send a keep-alive once a subscription was idle for its interval, checking every half interval.
+func KeepAliveWindow(interval time.Duration) time.Duration {
+	return 2 * interval
+}
+
+// Frame is a single message on a subscription stream. The server sends every frame but FrameStart as a NATS
+// request on the subject of the subscription and waits for the client to reply before sending the next one.
+type Frame struct {
+	Type    FrameType       `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Subject string          `json:"subject,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// KeepAlive is the keep-alive interval of the subscription in milliseconds, set on the acknowledgement of a
+	// FrameStart.
+	KeepAlive int64 `json:"keepAlive,omitempty"`
+}
+
+// SubscribeSubject is the subject every replica of a service listens on for FrameStart.
+func SubscribeSubject(serviceName, graphEntrypoint string) string {
+	return fmt.Sprintf("%s.%s.subscribe", serviceName, graphEntrypoint)
+}
+
+// StopSubject is the subject the replica running a subscription listens on for FrameStop.
+func StopSubject(subscriptionSubject string) string {
+	return fmt.Sprintf("%s.stop", subscriptionSubject)
+}
@@ -0,0 +1,82 @@
+// Package manifest describes the operations a generated client may send to a remote service. clientgen writes one
+// manifest.json alongside every generated.go, and the server can load them to only execute the operations they list.
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"sort"
+)
+
+// Filename is the name of the manifest clientgen writes next to the generated client.
+const Filename = "manifest.json"
+
+// Operation is a single operation document known at build time.
+type Operation struct {
+	Name     string `json:"name"`
+	Hash     string `json:"hash"`
+	Document string `json:"document"`
+}
+
+// Manifest lists the operations a generated client sends to Service.
+type Manifest struct {
+	Service    string      `json:"service"`
+	Operations []Operation `json:"operations"`
+}
+
+// New returns an empty manifest for the operations sent to service.
+func New(service string) *Manifest {
+	return &Manifest{Service: service, Operations: make([]Operation, 0)}
+}
+
+// Hash returns the hex encoded SHA-256 hash of document, as sent by clients using automatic persisted queries.
+func Hash(document string) string {
+	sum := sha256.Sum256([]byte(document))
+	return hex.EncodeToString(sum[:])
+}
+
+// Add records the operation name along with its document and hash.
+func (m *Manifest) Add(name, document string) {
+	m.Operations = append(m.Operations, Operation{Name: name, Hash: Hash(document), Document: document})
+}
+
+// Parse decodes a manifest, e.g. one embedded with go:embed, and verifies the hash of every operation.
+func Parse(b []byte) (*Manifest, error) {
+	m := &Manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, errors.Wrap(err, "failed to decode manifest")
+	}
+
+	for _, op := range m.Operations {
+		if op.Hash != Hash(op.Document) {
+			return nil, errors.Errorf("hash of operation %s does not match its document", op.Name)
+		}
+	}
+
+	return m, nil
+}
+
+// Load reads and parses the manifest at path.
+func Load(path string) (*Manifest, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read manifest")
+	}
+
+	return Parse(b)
+}
+
+// Write saves the manifest at path, with its operations sorted by name so regenerating it yields the same file.
+func (m *Manifest) Write(path string) error {
+	sort.Slice(m.Operations, func(i, j int) bool { return m.Operations[i].Name < m.Operations[j].Name })
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode manifest")
+	}
+
+	return errors.Wrap(ioutil.WriteFile(path, append(b, '\n'), 0644), "failed to write manifest")
+}
@@ -0,0 +1,33 @@
+package manifest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteLoad(t *testing.T) {
+	t.Parallel()
+
+	m := New("ms-users")
+	m.Add("ListUsers", `query ListUsers { users { id } }`)
+	m.Add("GetUser", `query GetUser { user { id } }`)
+
+	path := filepath.Join(t.TempDir(), Filename)
+	require.NoError(t, m.Write(path))
+
+	loaded, err := Load(path)
+	require.NoError(t, err)
+	require.Equal(t, "ms-users", loaded.Service)
+	require.Len(t, loaded.Operations, 2)
+	require.Equal(t, "GetUser", loaded.Operations[0].Name)
+	require.Equal(t, Hash(`query GetUser { user { id } }`), loaded.Operations[0].Hash)
+}
+
+func TestParseRejectsTamperedDocuments(t *testing.T) {
+	t.Parallel()
+
+	_, err := Parse([]byte(`{"service":"ms-users","operations":[{"name":"GetUser","hash":"abc","document":"query GetUser { user { id } }"}]}`))
+	require.Error(t, err)
+}
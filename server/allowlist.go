@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/Just4Ease/graphrpc/manifest"
+	"github.com/pkg/errors"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"strings"
+)
+
+const operationNotAllowed = "OPERATION_NOT_ALLOWED"
+
+// RegisterOperationManifests registers the manifests clientgen wrote for the clients of this service. When automatic
+// persisted queries are enabled, their documents are preloaded into the cache so callers never have to send them. See
+// EnforceOperationAllowlist to reject every other operation.
+func RegisterOperationManifests(manifests ...*manifest.Manifest) Option {
+	return func(o *Options) error {
+		if o.operationManifest == nil {
+			o.operationManifest = make(map[string]string)
+		}
+
+		if o.manifestOperations == nil {
+			o.manifestOperations = make(map[string]struct{})
+		}
+
+		for _, m := range manifests {
+			if m == nil {
+				return errors.New("cannot register a nil manifest")
+			}
+
+			for _, op := range m.Operations {
+				o.operationManifest[op.Hash] = op.Document
+				o.manifestOperations[op.Name] = struct{}{}
+			}
+		}
+		return nil
+	}
+}
+
+// EnforceOperationAllowlist rejects every operation whose document is not in a registered manifest, over both NATS
+// and HTTP, turning the graph into a closed contract between services. Introspection stays allowed so clients can
+// still be generated against the service.
+func EnforceOperationAllowlist() Option {
+	return func(o *Options) error {
+		o.enforceOperationAllowlist = true
+		return nil
+	}
+}
+
+// seedPersistedQueries makes the documents of the registered manifests known to the persisted query cache.
+func seedPersistedQueries(cache graphql.Cache, documents map[string]string) {
+	for hash, document := range documents {
+		cache.Add(context.Background(), hash, document)
+	}
+}
+
+// operationAllowlist is a gqlgen extension rejecting operations missing from the registered manifests.
+type operationAllowlist struct {
+	documents map[string]string
+}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.OperationContextMutator
+} = operationAllowlist{}
+
+func (operationAllowlist) ExtensionName() string {
+	return "OperationAllowlist"
+}
+
+func (operationAllowlist) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (a operationAllowlist) MutateOperationContext(_ context.Context, rc *graphql.OperationContext) *gqlerror.Error {
+	if _, ok := a.documents[manifest.Hash(rc.RawQuery)]; ok || isIntrospection(rc) {
+		return nil
+	}
+
+	name := rc.OperationName
+	if name == empty {
+		name = "anonymous operation"
+	}
+
+	return &gqlerror.Error{
+		Message:    name + " is not in the operation allowlist",
+		Extensions: map[string]interface{}{"code": operationNotAllowed},
+	}
+}
+
+// isIntrospection reports whether the operation only selects introspection fields, e.g. __schema or __typename.
+func isIntrospection(rc *graphql.OperationContext) bool {
+	if rc.Operation == nil {
+		return false
+	}
+
+	fields := graphql.CollectFields(rc, rc.Operation.SelectionSet, nil)
+	for _, field := range fields {
+		if !strings.HasPrefix(field.Name, "__") {
+			return false
+		}
+	}
+	return len(fields) != 0
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Just4Ease/graphrpc/manifest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOperationAllowlist(t *testing.T) {
+	url := runNATS(t)
+
+	const getReplica = `query GetReplica { replica }`
+	m := manifest.New("ms-allowlist")
+	m.Add("GetReplica", getReplica)
+
+	var handled, inFlight, maxSeen int64
+	schema := replicaExecutableSchema{name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen}
+	startReplica(t, url, "ms-allowlist", schema, RegisterOperationManifests(m), EnforceOperationAllowlist())
+
+	c := newReplicaClient(t, url, "ms-allowlist")
+
+	res := &struct{ Replica string }{}
+	require.NoError(t, c.Exec(context.Background(), "GetReplica", getReplica, res, nil, nil))
+	require.Equal(t, "replica", res.Replica)
+
+	err := c.Exec(context.Background(), "ListReplicas", `query ListReplicas { replica }`, res, nil, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "ListReplicas is not in the operation allowlist")
+
+	// Introspection is allowed so clients can still be generated.
+	typename := &struct {
+		Typename string `graphql:"__typename"`
+	}{}
+	require.NoError(t, c.Exec(context.Background(), "", `{ __typename }`, typename, nil, nil))
+
+	require.Equal(t, int64(1), handled)
+}
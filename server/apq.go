@@ -0,0 +1,23 @@
+package server
+
+import (
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler/lru"
+)
+
+const defaultPersistedQueryCacheSize = 1000
+
+// EnableAutomaticPersistedQueries lets callers send the hash of a document instead of the document itself. The
+// documents are kept in cache, which is shared by the NATS and HTTP transports as it backs gqlgen's
+// AutomaticPersistedQuery extension. An LRU cache of 1000 documents is used when cache is nil; pass a shared cache,
+// e.g. backed by Redis, for replicas to learn documents from one another.
+func EnableAutomaticPersistedQueries(cache graphql.Cache) Option {
+	return func(o *Options) error {
+		if cache == nil {
+			cache = lru.New(defaultPersistedQueryCacheSize)
+		}
+
+		o.persistedQueryCache = cache
+		return nil
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/axon/v2/options"
+	"github.com/Just4Ease/axon/v2/systems/jetstream"
+	"github.com/Just4Ease/graphrpc/client"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutomaticPersistedQueries(t *testing.T) {
+	url := runNATS(t)
+
+	mu := sync.Mutex{}
+	var withDocument, withoutDocument int
+	countDocuments := func(next NATSHandler) NATSHandler {
+		return func(ctx context.Context, mg *messages.Message) (*messages.Message, error) {
+			body := &struct{ Query string }{}
+			if err := json.Unmarshal(mg.Body, body); err == nil && !strings.Contains(body.Query, "__typename") {
+				mu.Lock()
+				if body.Query == empty {
+					withoutDocument++
+				} else {
+					withDocument++
+				}
+				mu.Unlock()
+			}
+			return next(ctx, mg)
+		}
+	}
+
+	var handled, inFlight, maxSeen int64
+	schema := replicaExecutableSchema{name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen}
+	startReplica(t, url, "ms-apq", schema, EnableAutomaticPersistedQueries(nil), UseNATSMiddlewares(countDocuments))
+
+	store, err := jetstream.Init(options.Options{ServiceName: "ms-apq-client", Address: url})
+	require.NoError(t, err)
+	t.Cleanup(store.Close)
+
+	c, err := client.NewClient(store, client.SetRemoteServiceName("ms-apq"), client.SetRemoteGraphQLPath("graph"), client.EnableAutomaticPersistedQueries())
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		res := &struct{ Replica string }{}
+		require.NoError(t, c.Exec(context.Background(), "GetReplica", `query GetReplica { replica }`, res, nil, nil))
+		require.Equal(t, "replica", res.Replica)
+	}
+
+	// The document is only sent once, after the first hash missed the cache.
+	require.Equal(t, 1, withDocument)
+	require.Equal(t, 2, withoutDocument)
+	require.Equal(t, int64(2), handled)
+}
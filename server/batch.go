@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/pkg/errors"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"net/http"
+	"sync"
+)
+
+const batchOperation = "batch"
+
+// SetMaxBatchSize bounds how many operations a single batch may carry, protocol.DefaultMaxBatchSize by default.
+// Larger batches are rejected.
+func SetMaxBatchSize(n int) Option {
+	return func(o *Options) error {
+		if n <= 0 {
+			return errors.New("max batch size must be greater than zero")
+		}
+
+		o.maxBatchSize = n
+		return nil
+	}
+}
+
+// executeBatch runs the operations of a batch and replies with their results in order. The batch shares the deadline,
+// headers and concurrency slot of the message carrying it: its operations run one after the other on that slot, and
+// concurrently on the slots that are free when it starts, without waiting for more. See SetMaxConcurrency.
+func (s *Server) executeBatch(ctx context.Context, mg *messages.Message) (*messages.Message, error) {
+	requests := make([]json.RawMessage, 0)
+	if err := json.Unmarshal(mg.Body, &requests); err != nil {
+		return nil, errors.Wrap(err, "failed to decode batch")
+	}
+
+	if len(requests) > s.opts.maxBatchSize {
+		return nil, &protocol.Error{
+			Kind:    protocol.ErrorKindTransport,
+			Status:  http.StatusRequestEntityTooLarge,
+			Message: fmt.Sprintf("batch of %d operations exceeds the limit of %d", len(requests), s.opts.maxBatchSize),
+		}
+	}
+
+	op := operationInfoFromContext(ctx)
+	if op != nil {
+		op.name = batchOperation
+		op.operationType = batchOperation
+	}
+
+	// Every operation records its own outcome in the response, not in the operation info of the batch.
+	operationCtx := context.WithValue(ctx, operationInfoKey{}, (*operationInfo)(nil))
+
+	pending := make(chan int, len(requests))
+	for i := range requests {
+		pending <- i
+	}
+	close(pending)
+
+	results := make([]protocol.BatchResult, len(requests))
+	run := func() {
+		for i := range pending {
+			results[i] = s.executeBatchOperation(operationCtx, requests[i], mg.Header)
+		}
+	}
+
+	wg := sync.WaitGroup{}
+	for workers := 1; workers < len(requests); workers++ {
+		release, ok := s.tryAcquireSlot()
+		if !ok {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer release()
+			run()
+		}()
+	}
+	run()
+	wg.Wait()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, ctx.Err()
+	}
+
+	for _, result := range results {
+		if op != nil && (result.Status != http.StatusOK || hasGraphErrors(result.Body)) {
+			op.graphErrors = true
+		}
+	}
+
+	b, err := json.Marshal(results)
+	if err != nil {
+		return nil, err
+	}
+
+	return replyWithStatus(mg, b, http.StatusOK), nil
+}
+
+func (s *Server) executeBatchOperation(ctx context.Context, request json.RawMessage, header map[string]string) protocol.BatchResult {
+	res, err := s.execute(ctx, request, header)
+	if err != nil {
+		b, _ := json.Marshal(&graphql.Response{Errors: gqlerror.List{{Message: err.Error()}}})
+		return protocol.BatchResult{Status: http.StatusInternalServerError, Body: b}
+	}
+
+	if res.body.Len() == 0 {
+		b, _ := json.Marshal(&graphql.Response{Errors: gqlerror.List{{Message: "internal server error"}}})
+		return protocol.BatchResult{Status: http.StatusInternalServerError, Body: b}
+	}
+
+	return protocol.BatchResult{Status: res.code, Body: res.body.Bytes()}
+}
+
+func hasGraphErrors(body []byte) bool {
+	res := &struct {
+		Errors []json.RawMessage `json:"errors"`
+	}{}
+
+	return json.Unmarshal(body, res) == nil && len(res.Errors) != 0
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/axon/v2/options"
+	"github.com/Just4Ease/axon/v2/systems/jetstream"
+	"github.com/Just4Ease/graphrpc/client"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+// countMessages is a NATSMiddleware counting the messages carrying a batch.
+func countMessages(batches *int64) NATSMiddleware {
+	return func(next NATSHandler) NATSHandler {
+		return func(ctx context.Context, mg *messages.Message) (*messages.Message, error) {
+			if protocol.IsBatch(mg.Body) {
+				atomic.AddInt64(batches, 1)
+			}
+			return next(ctx, mg)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	url := runNATS(t)
+
+	var handled, inFlight, maxSeen, batches int64
+	schema := replicaExecutableSchema{name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen}
+	startReplica(t, url, "ms-batch", schema, SetMaxBatchSize(2), UseNATSMiddlewares(countMessages(&batches)))
+
+	c := newReplicaClient(t, url, "ms-batch")
+
+	first, second := &struct{ Replica string }{}, &struct{ Replica string }{}
+	b := c.NewBatch()
+	firstResult := b.Add("First", `query First { replica }`, first, nil)
+	secondResult := b.Add("Second", `query Second { unknown }`, second, nil)
+	require.NoError(t, b.Exec(context.Background(), nil))
+
+	require.NoError(t, firstResult.Err())
+	require.Equal(t, "replica", first.Replica)
+	require.Error(t, secondResult.Err())
+	require.Equal(t, int64(1), batches)
+
+	// Batches over the limit are rejected as a whole.
+	b = c.NewBatch()
+	for i := 0; i < 3; i++ {
+		b.Add("", `{ replica }`, &struct{ Replica string }{}, nil)
+	}
+	err := b.Exec(context.Background(), nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "exceeds the limit of 2")
+}
+
+func TestAutomaticBatching(t *testing.T) {
+	url := runNATS(t)
+
+	var handled, inFlight, maxSeen, batches int64
+	schema := replicaExecutableSchema{name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen}
+	startReplica(t, url, "ms-batching", schema, UseNATSMiddlewares(countMessages(&batches)))
+
+	store, err := jetstream.Init(options.Options{ServiceName: "ms-batching-client", Address: url})
+	require.NoError(t, err)
+	t.Cleanup(store.Close)
+
+	c, err := client.NewClient(store, client.SetRemoteServiceName("ms-batching"), client.SetRemoteGraphQLPath("graph"), client.EnableBatching(100*time.Millisecond, 5))
+	require.NoError(t, err)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := &struct{ Replica string }{}
+			require.NoError(t, c.Exec(context.Background(), "", `{ replica }`, res, nil, nil))
+			require.Equal(t, "replica", res.Replica)
+		}()
+	}
+	wg.Wait()
+
+	// The calls fill two batches of 5.
+	require.Equal(t, int64(10), handled)
+	require.Equal(t, int64(2), batches)
+
+	// Batches larger than servers accept by default are refused up front.
+	_, err = client.NewClient(store, client.SetRemoteServiceName("ms-batching"), client.EnableBatching(time.Millisecond, protocol.DefaultMaxBatchSize+1))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "must not exceed 100")
+}
+
+func TestBatchRespectsMaxConcurrency(t *testing.T) {
+	url := runNATS(t)
+
+	var handled, inFlight, maxSeen int64
+	schema := replicaExecutableSchema{name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen, delay: 50 * time.Millisecond}
+	startReplica(t, url, "ms-batch-limited", schema, SetMaxConcurrency(2))
+
+	c := newReplicaClient(t, url, "ms-batch-limited")
+
+	b := c.NewBatch()
+	results := make([]*client.BatchResult, 0, 6)
+	for i := 0; i < 6; i++ {
+		results = append(results, b.Add("", `{ replica }`, &struct{ Replica string }{}, nil))
+	}
+	require.NoError(t, b.Exec(context.Background(), nil))
+	for _, result := range results {
+		require.NoError(t, result.Err())
+	}
+
+	// The operations of a batch only run on the handler slots that are free.
+	require.Equal(t, int64(6), handled)
+	require.Equal(t, int64(2), maxSeen)
+}
+
+func TestBatchRunsClientInterceptors(t *testing.T) {
+	url := runNATS(t)
+
+	var handled, inFlight, maxSeen, batches int64
+	schema := replicaExecutableSchema{name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen}
+	startReplica(t, url, "ms-batch-intercepted", schema, UseNATSMiddlewares(countMessages(&batches)))
+
+	store, err := jetstream.Init(options.Options{ServiceName: "ms-batch-intercepted-client", Address: url})
+	require.NoError(t, err)
+	t.Cleanup(store.Close)
+
+	errSkipped := errors.New("skipped")
+	var intercepted int64
+	intercept := func(ctx context.Context, operationName, query string, respData interface{}, vars map[string]interface{}, headers client.Header, next client.Invoker) error {
+		atomic.AddInt64(&intercepted, 1)
+		switch operationName {
+		case "Skipped":
+			return errSkipped
+		case "Tenant":
+			headers["X-Tenant"] = "other"
+		}
+		return next(ctx, operationName, query, respData, vars, headers)
+	}
+	c, err := client.NewClient(store, client.SetRemoteServiceName("ms-batch-intercepted"), client.SetRemoteGraphQLPath("graph"), client.UseInterceptors(intercept))
+	require.NoError(t, err)
+
+	b := c.NewBatch()
+	results := make([]*client.BatchResult, 0, 3)
+	for i := 0; i < 3; i++ {
+		results = append(results, b.Add("", `{ replica }`, &struct{ Replica string }{}, nil))
+	}
+	skipped := b.Add("Skipped", `query Skipped { replica }`, &struct{ Replica string }{}, nil)
+	tenant := &struct{ Replica string }{}
+	tenantResult := b.Add("Tenant", `query Tenant { replica }`, tenant, nil)
+	require.NoError(t, b.Exec(context.Background(), nil))
+
+	require.Equal(t, int64(5), intercepted)
+	for _, result := range results {
+		require.NoError(t, result.Err())
+	}
+	require.Equal(t, errSkipped, skipped.Err())
+	require.NoError(t, tenantResult.Err())
+	require.Equal(t, "replica", tenant.Replica)
+
+	// The operations the interceptors leave with the same headers share a message, the others are sent on their own.
+	require.Equal(t, int64(4), handled)
+	require.Equal(t, int64(1), batches)
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/Just4Ease/graphrpc/internal/cache"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/Just4Ease/graphrpc/manifest"
+	"github.com/vektah/gqlparser/v2/formatter"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultResponseCacheSize = 1000
+
+// defaultResponseCacheHeaders tell cached replies apart when EnableResponseCache is given no key headers.
+var defaultResponseCacheHeaders = []string{"Authorization"}
+
+// ResponseCache stores the replies of cacheable queries. Implementations, e.g. backed by Redis to share the cache
+// across replicas, must be safe for concurrent use; failures are treated as cache misses.
+type ResponseCache interface {
+	// Get returns the reply stored under key, unless it expired.
+	Get(ctx context.Context, key string) ([]byte, bool)
+	// Set stores a reply under key for ttl.
+	Set(ctx context.Context, key string, body []byte, ttl time.Duration)
+}
+
+// EnableResponseCache serves repeated queries arriving over NATS from cache, without invoking resolvers. The lookup
+// happens once the request went through the middlewares set with UseMiddlewares and the operation was validated, so
+// hits are authenticated and authorized like any other call. Queries are keyed by their normalized document, variables
+// and the given keyHeaders, "Authorization" by default, so callers only share the replies of callers presenting the
+// same credentials. Only successful queries whose every field is covered by @cacheControl are cached, for the smallest
+// maxAge among them, and never when one of them has the PRIVATE scope. Callers bypass the cache by setting the
+// X-GraphRPC-Cache-Control header to "no-cache". An in-memory LRU cache of 1000 replies is used when cache is nil.
+func EnableResponseCache(cache ResponseCache, keyHeaders ...string) Option {
+	return func(o *Options) error {
+		if cache == nil {
+			cache = NewLRUResponseCache(defaultResponseCacheSize)
+		}
+
+		if len(keyHeaders) == 0 {
+			keyHeaders = defaultResponseCacheHeaders
+		}
+
+		o.responseCache = cache
+		o.responseCacheHeaders = keyHeaders
+		return nil
+	}
+}
+
+type lruResponseCache struct {
+	lru *cache.LRU
+}
+
+// NewLRUResponseCache returns an in-memory ResponseCache holding up to size replies.
+func NewLRUResponseCache(size int) ResponseCache {
+	if size <= 0 {
+		size = defaultResponseCacheSize
+	}
+
+	return lruResponseCache{lru: cache.NewLRU(size)}
+}
+
+func (l lruResponseCache) Get(_ context.Context, key string) ([]byte, bool) {
+	return l.lru.Get(key)
+}
+
+func (l lruResponseCache) Set(_ context.Context, key string, body []byte, ttl time.Duration) {
+	l.lru.Set(key, body, ttl, nil)
+}
+
+// responseCaching is a gqlgen extension serving the queries executed over NATS from a ResponseCache.
+type responseCaching struct {
+	cache      ResponseCache
+	keyHeaders []string
+}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.OperationInterceptor
+} = responseCaching{}
+
+func (responseCaching) ExtensionName() string {
+	return "GraphRPCResponseCache"
+}
+
+func (responseCaching) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (c responseCaching) InterceptOperation(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+	header, isNATS := ctx.Value(natsHeaderKey{}).(map[string]string)
+	if !isNATS || headerValue(header, protocol.HeaderCacheControl) == protocol.CacheControlNoCache {
+		return next(ctx)
+	}
+
+	rc := graphql.GetOperationContext(ctx)
+	policy, ok := cachePolicyOf(rc)
+	if !ok || policy.private {
+		return next(ctx)
+	}
+
+	key, ok := c.key(rc, header)
+	if !ok {
+		return next(ctx)
+	}
+
+	if cached, ok := c.cache.Get(ctx, key); ok {
+		response := &graphql.Response{}
+		if err := json.Unmarshal(cached, response); err == nil {
+			return graphql.OneShot(response)
+		}
+	}
+
+	responses := next(ctx)
+	stored := false
+	return func(ctx context.Context) *graphql.Response {
+		response := responses(ctx)
+		if response == nil || stored || len(response.Errors) != 0 {
+			return response
+		}
+		stored = true
+
+		if b, err := json.Marshal(response); err == nil {
+			c.cache.Set(ctx, key, b, time.Duration(policy.maxAge)*time.Second)
+		}
+		return response
+	}
+}
+
+// key identifies a query by its normalized document, operation, variables and key headers.
+func (c responseCaching) key(rc *graphql.OperationContext, header map[string]string) (string, bool) {
+	if rc.Doc == nil {
+		return empty, false
+	}
+
+	normalized := &bytes.Buffer{}
+	formatter.NewFormatter(normalized).FormatQueryDocument(rc.Doc)
+
+	variables, err := json.Marshal(rc.Variables)
+	if err != nil {
+		return empty, false
+	}
+
+	headers := make([]string, 0, len(c.keyHeaders))
+	for _, name := range c.keyHeaders {
+		headers = append(headers, strings.ToLower(name)+"="+headerValue(header, name))
+	}
+	sort.Strings(headers)
+
+	return manifest.Hash(strings.Join([]string{normalized.String(), rc.OperationName, string(variables), strings.Join(headers, "&")}, "\x00")), true
+}
+
+// headerValue looks a header up regardless of the case of its name.
+func headerValue(header map[string]string, name string) string {
+	if v, ok := header[name]; ok {
+		return v
+	}
+
+	for k, v := range header {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return empty
+}
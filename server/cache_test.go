@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Just4Ease/graphrpc/client"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestResponseCache(t *testing.T) {
+	url := runNATS(t)
+
+	schema := gqlparser.MustLoadSchema(&ast.Source{Input: CacheControlDirectiveSDL + `
+		type Query { replica: String! @cacheControl(maxAge: 60) }
+	`})
+
+	var handled, inFlight, maxSeen int64
+	startReplica(t, url, "ms-cache", replicaExecutableSchema{schema: schema, name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen},
+		EnableResponseCache(nil, "Authorization"))
+
+	c := newReplicaClient(t, url, "ms-cache")
+	exec := func(query string, headers client.Header) {
+		res := &struct{ Replica string }{}
+		require.NoError(t, c.Exec(context.Background(), "GetReplica", query, res, nil, headers))
+		require.Equal(t, "replica", res.Replica)
+	}
+
+	exec(`query GetReplica { replica }`, client.Header{"Authorization": "alice"})
+	// The same document formatted differently is a hit.
+	exec("query GetReplica {\n  replica\n}", client.Header{"Authorization": "alice", "X-Trace": "other"})
+	require.Equal(t, int64(1), handled)
+
+	// Another caller has its own entry.
+	exec(`query GetReplica { replica }`, client.Header{"Authorization": "bob"})
+	require.Equal(t, int64(2), handled)
+
+	exec(`query GetReplica { replica }`, client.Header{"Authorization": "alice", client.HeaderCacheControl: client.CacheControlNoCache})
+	require.Equal(t, int64(3), handled)
+}
+
+func TestResponseCacheHitsGoThroughMiddlewares(t *testing.T) {
+	url := runNATS(t)
+
+	schema := gqlparser.MustLoadSchema(&ast.Source{Input: CacheControlDirectiveSDL + `
+		type Query { replica: String! @cacheControl(maxAge: 60) }
+	`})
+
+	banned := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Caller") == "banned" {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	var handled, inFlight, maxSeen int64
+	startReplica(t, url, "ms-cache-auth", replicaExecutableSchema{schema: schema, name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen},
+		EnableResponseCache(nil, "X-Tenant"), UseMiddlewares(banned))
+
+	c := newReplicaClient(t, url, "ms-cache-auth")
+	const query = `query GetReplica { replica }`
+
+	res := &struct{ Replica string }{}
+	require.NoError(t, c.Exec(context.Background(), "GetReplica", query, res, nil, client.Header{"X-Tenant": "acme", "X-Caller": "alice"}))
+	require.NoError(t, c.Exec(context.Background(), "GetReplica", query, res, nil, client.Header{"X-Tenant": "acme", "X-Caller": "bob"}))
+	require.Equal(t, int64(1), handled)
+
+	// The reply is cached under the same key, yet the middleware still turns the caller away.
+	err := c.Exec(context.Background(), "GetReplica", query, res, nil, client.Header{"X-Tenant": "acme", "X-Caller": "banned"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "403")
+}
+
+func TestResponseCacheKeysOnAuthorizationByDefault(t *testing.T) {
+	url := runNATS(t)
+
+	schema := gqlparser.MustLoadSchema(&ast.Source{Input: CacheControlDirectiveSDL + `
+		type Query { replica: String! @cacheControl(maxAge: 60) }
+	`})
+
+	var handled, inFlight, maxSeen int64
+	startReplica(t, url, "ms-cache-default", replicaExecutableSchema{schema: schema, name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen},
+		EnableResponseCache(nil))
+
+	c := newReplicaClient(t, url, "ms-cache-default")
+	exec := func(authorization string) {
+		res := &struct{ Replica string }{}
+		require.NoError(t, c.Exec(context.Background(), "GetReplica", `query GetReplica { replica }`, res, nil, client.Header{"Authorization": authorization}))
+	}
+
+	exec("alice")
+	exec("alice")
+	require.Equal(t, int64(1), handled)
+
+	exec("bob")
+	require.Equal(t, int64(2), handled)
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"strconv"
+)
+
+// CacheControlDirectiveSDL declares the @cacheControl directive. Add it to the schema to let callers cache the result
+// of queries, e.g. `countries: [Country!]! @cacheControl(maxAge: 3600)`, and set scope: PRIVATE on fields whose value
+// depends on the caller. Like @timeout, either mark it skip_runtime in gqlgen.yml or wire CacheControlDirective into
+// the generated DirectiveRoot.
+const CacheControlDirectiveSDL = `enum CacheControlScope { PUBLIC PRIVATE }
+directive @cacheControl(maxAge: Int!, scope: CacheControlScope = PUBLIC) on FIELD_DEFINITION`
+
+// CacheControlDirective is the resolver-side implementation of @cacheControl, which only passes through: the hint is
+// added to the response by the server.
+func CacheControlDirective(ctx context.Context, _ interface{}, next graphql.Resolver, _ int) (interface{}, error) {
+	return next(ctx)
+}
+
+// cacheControlExtension is the response extension key holding the cache hint of a query, e.g. {"maxAge": 60}, or
+// {"maxAge": 60, "scope": "PRIVATE"} when the reply depends on the caller.
+const cacheControlExtension = "cacheControl"
+
+// cacheHint is a gqlgen extension adding the cache hint of successful queries to their response extensions.
+type cacheHint struct{}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.ResponseInterceptor
+} = cacheHint{}
+
+func (cacheHint) ExtensionName() string {
+	return "GraphRPCCacheControl"
+}
+
+func (cacheHint) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (cacheHint) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	response := next(ctx)
+	if response == nil || len(response.Errors) != 0 || !graphql.HasOperationContext(ctx) {
+		return response
+	}
+
+	policy, ok := cachePolicyOf(graphql.GetOperationContext(ctx))
+	if !ok {
+		return response
+	}
+
+	hint := map[string]interface{}{"maxAge": policy.maxAge}
+	if policy.private {
+		hint["scope"] = cacheScopePrivate
+	}
+
+	if response.Extensions == nil {
+		response.Extensions = make(map[string]interface{})
+	}
+	response.Extensions[cacheControlExtension] = hint
+	return response
+}
+
+const cacheScopePrivate = "PRIVATE"
+
+// cachePolicy is how the result of a query may be cached.
+type cachePolicy struct {
+	maxAge  int  // seconds, the smallest @cacheControl maxAge among the fields of the query
+	private bool // one of the fields has the PRIVATE scope
+}
+
+// cachePolicyOf returns how the result of a query may be cached, considering every field it resolves, nested ones
+// and the ones of fragments included. Fields without @cacheControl inherit the hint of their parent when they are
+// leaves, e.g. the name of a country; root fields and fields selecting others need a hint of their own, or the query
+// is not cacheable.
+func cachePolicyOf(rc *graphql.OperationContext) (cachePolicy, bool) {
+	if rc.Operation == nil || rc.Operation.Operation != ast.Query {
+		return cachePolicy{}, false
+	}
+
+	policy := cachePolicy{maxAge: -1}
+	if !policy.visit(rc.Operation.SelectionSet, true, rc.Doc) {
+		return cachePolicy{}, false
+	}
+
+	return policy, policy.maxAge > 0
+}
+
+func (p *cachePolicy) visit(selections ast.SelectionSet, root bool, doc *ast.QueryDocument) bool {
+	for _, selection := range selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if selection.Name == "__typename" {
+				continue
+			}
+
+			if !p.visitField(selection, root) || !p.visit(selection.SelectionSet, false, doc) {
+				return false
+			}
+		case *ast.InlineFragment:
+			if !p.visit(selection.SelectionSet, root, doc) {
+				return false
+			}
+		case *ast.FragmentSpread:
+			fragment := selection.Definition
+			if fragment == nil && doc != nil {
+				fragment = doc.Fragments.ForName(selection.Name)
+			}
+
+			if fragment == nil || !p.visit(fragment.SelectionSet, root, doc) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (p *cachePolicy) visitField(field *ast.Field, root bool) bool {
+	if field.Definition == nil {
+		return false
+	}
+
+	directive := field.Definition.Directives.ForName("cacheControl")
+	if directive == nil {
+		return !root && len(field.SelectionSet) == 0
+	}
+
+	arg := directive.Arguments.ForName("maxAge")
+	if arg == nil || arg.Value == nil {
+		return false
+	}
+
+	age, err := strconv.Atoi(arg.Value.Raw)
+	if err != nil {
+		return false
+	}
+
+	if p.maxAge == -1 || age < p.maxAge {
+		p.maxAge = age
+	}
+
+	if scope := directive.Arguments.ForName("scope"); scope != nil && scope.Value != nil && scope.Value.Raw == cacheScopePrivate {
+		p.private = true
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/Just4Ease/axon/v2/options"
+	"github.com/Just4Ease/axon/v2/systems/jetstream"
+	"github.com/Just4Ease/graphrpc/client"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestCacheControlHint(t *testing.T) {
+	url := runNATS(t)
+
+	schema := gqlparser.MustLoadSchema(&ast.Source{Input: CacheControlDirectiveSDL + `
+		type Query { replica: String! @cacheControl(maxAge: 60) }
+	`})
+
+	var handled, inFlight, maxSeen int64
+	startReplica(t, url, "ms-cache-hint", replicaExecutableSchema{schema: schema, name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen})
+
+	store, err := jetstream.Init(options.Options{ServiceName: "ms-cache-hint-client", Address: url})
+	require.NoError(t, err)
+	t.Cleanup(store.Close)
+
+	c, err := client.NewClient(store, client.SetRemoteServiceName("ms-cache-hint"), client.SetRemoteGraphQLPath("graph"), client.EnableResponseCache(nil))
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		res := &struct{ Replica string }{}
+		require.NoError(t, c.Exec(context.Background(), "GetReplica", `query GetReplica { replica }`, res, nil, nil))
+		require.Equal(t, "replica", res.Replica)
+	}
+
+	// The reply carried a one minute hint, so the later calls were served from the cache.
+	require.Equal(t, int64(1), handled)
+}
+
+func TestCachePolicy(t *testing.T) {
+	t.Parallel()
+	schema := gqlparser.MustLoadSchema(&ast.Source{Input: CacheControlDirectiveSDL + `
+		type Country {
+			code: String!
+			capital: City @cacheControl(maxAge: 600)
+			neighbours: [Country!]!
+		}
+		type City { name: String! }
+		type Viewer { name: String! }
+		type Query {
+			countries: [Country!]! @cacheControl(maxAge: 3600)
+			viewer: Viewer @cacheControl(maxAge: 60, scope: PRIVATE)
+			time: String!
+		}
+	`})
+
+	policy := func(query string) (cachePolicy, bool) {
+		doc, errs := gqlparser.LoadQuery(schema, query)
+		require.Nil(t, errs)
+		return cachePolicyOf(&graphql.OperationContext{Doc: doc, Operation: doc.Operations[0]})
+	}
+
+	// Leaves inherit the hint of their parent.
+	p, ok := policy(`{ countries { code } }`)
+	require.True(t, ok)
+	require.Equal(t, cachePolicy{maxAge: 3600}, p)
+
+	// Nested fields with a hint of their own lower the maxAge, including the ones of fragments.
+	p, ok = policy(`{ countries { code ...Capital } } fragment Capital on Country { capital { name } }`)
+	require.True(t, ok)
+	require.Equal(t, cachePolicy{maxAge: 600}, p)
+
+	p, ok = policy(`{ countries { code } viewer { name } }`)
+	require.True(t, ok)
+	require.Equal(t, cachePolicy{maxAge: 60, private: true}, p)
+
+	// Root fields and fields selecting others need a hint.
+	_, ok = policy(`{ countries { code } time }`)
+	require.False(t, ok)
+	_, ok = policy(`{ countries { neighbours { code } } }`)
+	require.False(t, ok)
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/pkg/errors"
+	"strings"
+	"sync"
+)
+
+// ErrServerOverloaded is returned to NATS requests shed because the server is at capacity and its wait queue is full.
+var ErrServerOverloaded = errors.New("server is overloaded")
+
+// SetQueueGroup names the NATS queue group the replicas of this service share, the service name of the
+// axon.EventStore by default. Every message is delivered to a single member of each group, so replicas of a group
+// split the load and no request is handled twice by it, while a replica in another group, e.g. a shadow deployment,
+// receives its own copy of every request.
+func SetQueueGroup(name string) Option {
+	return func(o *Options) error {
+		if strings.TrimSpace(name) == empty {
+			return errors.New("queue group name is required")
+		}
+
+		o.queueGroup = name
+		return nil
+	}
+}
+
+// SetMaxConcurrency lets up to n NATS handlers run at once on this replica, across every subject it serves. Messages
+// over the limit wait for a slot until their deadline, see SetMaxQueue. Without it, a replica handles the messages
+// of a subject one at a time.
+func SetMaxConcurrency(n int) Option {
+	return func(o *Options) error {
+		if n <= 0 {
+			return errors.New("max concurrency must be greater than zero")
+		}
+
+		o.maxConcurrency = n
+		return nil
+	}
+}
+
+// SetMaxQueue bounds how many messages may wait for a slot once SetMaxConcurrency is reached. Messages arriving to a
+// full queue are replied ErrServerOverloaded straight away, unless they are interactive and a batch message is
+// waiting, in which case the batch message is shed instead. See protocol.HeaderPriority.
+func SetMaxQueue(n int) Option {
+	return func(o *Options) error {
+		if n < 0 {
+			return errors.New("max queue must not be negative")
+		}
+
+		o.maxQueue = &n
+		return nil
+	}
+}
+
+// QueueGroup returns the NATS queue group shared by the replicas of this service.
+func (s *Server) QueueGroup() string {
+	if s.opts.queueGroup != empty {
+		return s.opts.queueGroup
+	}
+	return s.axonClient.GetServiceName()
+}
+
+// dispatch runs handle for a message received by a subscriber. Once SetMaxConcurrency is set, every message gets
+// its own goroutine and the limiter decides which ones run, wait or are shed; dispatched messages are tracked so
+// draining a subscriber waits for them.
+func (s *Server) dispatch(handle func()) {
+	if s.limiter == nil {
+		handle()
+		return
+	}
+
+	s.dispatched.Add(1)
+	go func() {
+		defer s.dispatched.Done()
+		handle()
+	}()
+}
+
+// acquireSlot waits for a free handler slot for mg. It returns ErrServerOverloaded when mg is shed and ctx's error
+// if mg runs out of time first. The returned func releases the slot.
+func (s *Server) acquireSlot(ctx context.Context, mg *messages.Message) (func(), error) {
+	if s.limiter == nil {
+		return func() {}, nil
+	}
+
+	if err := s.limiter.acquire(ctx, mg.Header[protocol.HeaderPriority] == protocol.PriorityBatch); err != nil {
+		return nil, err
+	}
+
+	return s.limiter.release, nil
+}
+
+// tryAcquireSlot takes a free handler slot without waiting for one. It returns false when none is free or messages
+// are waiting for one. The returned func releases the slot.
+func (s *Server) tryAcquireSlot() (func(), bool) {
+	if s.limiter == nil {
+		return func() {}, true
+	}
+
+	if !s.limiter.tryAcquire() {
+		return nil, false
+	}
+
+	return s.limiter.release, true
+}
+
+type waiter struct {
+	ready chan error // receives nil once handed a slot, ErrServerOverloaded when shed
+}
+
+// limiter bounds the number of running handlers, queueing the rest with interactive waiters ahead of batch ones.
+type limiter struct {
+	mu       sync.Mutex
+	limit    int
+	maxQueue int // negative means unbounded
+	inFlight int
+	queues   [2][]*waiter // interactive, batch
+}
+
+func newLimiter(limit, maxQueue int) *limiter {
+	return &limiter{limit: limit, maxQueue: maxQueue}
+}
+
+func (l *limiter) queued() int {
+	return len(l.queues[0]) + len(l.queues[1])
+}
+
+func (l *limiter) tryAcquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight < l.limit && l.queued() == 0 {
+		l.inFlight++
+		return true
+	}
+	return false
+}
+
+func (l *limiter) acquire(ctx context.Context, batch bool) error {
+	l.mu.Lock()
+	if l.inFlight < l.limit && l.queued() == 0 {
+		l.inFlight++
+		l.mu.Unlock()
+		return nil
+	}
+
+	if l.maxQueue >= 0 && l.queued() >= l.maxQueue {
+		// An interactive message takes the place of the most recent batch message rather than being shed itself.
+		if batch || len(l.queues[1]) == 0 {
+			l.mu.Unlock()
+			return ErrServerOverloaded
+		}
+
+		last := len(l.queues[1]) - 1
+		l.queues[1][last].ready <- ErrServerOverloaded
+		l.queues[1] = l.queues[1][:last]
+	}
+
+	priority := 0
+	if batch {
+		priority = 1
+	}
+
+	w := &waiter{ready: make(chan error, 1)}
+	l.queues[priority] = append(l.queues[priority], w)
+	l.mu.Unlock()
+
+	select {
+	case err := <-w.ready:
+		return err
+	case <-ctx.Done():
+		l.mu.Lock()
+		removed := l.remove(priority, w)
+		l.mu.Unlock()
+
+		// The slot was handed over, or the message shed, while ctx expired.
+		if !removed {
+			if err := <-w.ready; err == nil {
+				l.release()
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+func (l *limiter) remove(priority int, w *waiter) bool {
+	for i, queued := range l.queues[priority] {
+		if queued == w {
+			l.queues[priority] = append(l.queues[priority][:i], l.queues[priority][i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// release hands the slot over to the next waiter, or frees it.
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for priority := range l.queues {
+		if len(l.queues[priority]) != 0 {
+			w := l.queues[priority][0]
+			l.queues[priority] = l.queues[priority][1:]
+			w.ready <- nil
+			return
+		}
+	}
+
+	l.inFlight--
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Just4Ease/graphrpc/client"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterShedsLoad(t *testing.T) {
+	t.Parallel()
+	l := newLimiter(1, 1)
+	ctx := context.Background()
+
+	require.NoError(t, l.acquire(ctx, false))
+
+	batch := make(chan error, 1)
+	go func() { batch <- l.acquire(ctx, true) }()
+	require.Eventually(t, func() bool {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.queued() == 1
+	}, time.Second, time.Millisecond)
+
+	// The queue is full: an interactive call takes the place of the batch call, another batch call is shed.
+	interactive := make(chan error, 1)
+	go func() { interactive <- l.acquire(ctx, false) }()
+	require.ErrorIs(t, <-batch, ErrServerOverloaded)
+	require.ErrorIs(t, l.acquire(ctx, true), ErrServerOverloaded)
+
+	l.release()
+	require.NoError(t, <-interactive)
+	l.release()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	require.Equal(t, 0, l.inFlight)
+}
+
+func TestLimiterDeadline(t *testing.T) {
+	t.Parallel()
+	l := newLimiter(1, -1)
+	require.NoError(t, l.acquire(context.Background(), false))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, l.acquire(ctx, false), context.DeadlineExceeded)
+
+	l.release()
+	require.NoError(t, l.acquire(context.Background(), false))
+}
+
+func TestBurstIsShed(t *testing.T) {
+	url := runNATS(t)
+
+	var handled, inFlight, maxSeen int64
+	schema := replicaExecutableSchema{name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen, delay: 300 * time.Millisecond}
+	registry := prometheus.NewRegistry()
+	s := startReplica(t, url, "ms-shedding", schema, SetMaxConcurrency(1), SetMaxQueue(2), EnableMetrics(registry))
+	c := newReplicaClient(t, url, "ms-shedding")
+
+	exec := func(ctx context.Context) error {
+		return c.Exec(ctx, "", `{ replica }`, &struct{ Replica string }{}, nil, nil)
+	}
+	queued := func(interactive, batch int) func() bool {
+		return func() bool {
+			s.limiter.mu.Lock()
+			defer s.limiter.mu.Unlock()
+			return s.limiter.inFlight == 1 && len(s.limiter.queues[0]) == interactive && len(s.limiter.queues[1]) == batch
+		}
+	}
+
+	var shed int64
+	wg := sync.WaitGroup{}
+	run := func(ctx context.Context, expectShed bool) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := exec(ctx)
+			if !expectShed {
+				require.NoError(t, err)
+				return
+			}
+
+			var overloadedErr *client.OverloadedError
+			require.True(t, errors.As(err, &overloadedErr), "unexpected error: %v", err)
+			atomic.AddInt64(&shed, 1)
+		}()
+	}
+
+	// One query takes the only slot, a burst of batch queries fills the queue and the rest of it is shed.
+	run(context.Background(), false)
+	require.Eventually(t, queued(0, 0), 5*time.Second, time.Millisecond)
+
+	batch := client.WithPriority(context.Background(), client.PriorityBatch)
+	for i := 0; i < 10; i++ {
+		run(batch, true)
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&shed) == 8 }, 5*time.Second, time.Millisecond)
+	require.True(t, queued(0, 2)())
+
+	// Interactive queries arriving to the full queue take the place of the queued batch queries.
+	for i := 0; i < 2; i++ {
+		run(context.Background(), false)
+	}
+	wg.Wait()
+
+	require.Equal(t, int64(10), shed)
+	require.Equal(t, int64(3), handled)
+	require.Equal(t, int64(1), maxSeen)
+
+	// Shed calls are recorded along with the others.
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	counts := make(map[string]float64)
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if family.GetName() == "graphrpc_server_errors_total" && label.GetName() == "kind" {
+					counts[label.GetValue()] += m.GetCounter().GetValue()
+				}
+			}
+			if family.GetName() == "graphrpc_server_requests_total" {
+				counts["requests"] += m.GetCounter().GetValue()
+			}
+		}
+	}
+	require.Equal(t, float64(13), counts["requests"])
+	require.Equal(t, float64(10), counts[string(protocol.ErrorKindOverloaded)])
+}
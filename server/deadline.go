@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// cancelRegistry keeps the cancel functions of in-flight calls, keyed by request id.
+type cancelRegistry struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+func newCancelRegistry() *cancelRegistry {
+	return &cancelRegistry{cancels: make(map[string]context.CancelFunc)}
+}
+
+func (r *cancelRegistry) add(id string, cancel context.CancelFunc) {
+	r.mu.Lock()
+	r.cancels[id] = cancel
+	r.mu.Unlock()
+}
+
+func (r *cancelRegistry) remove(id string) {
+	r.mu.Lock()
+	delete(r.cancels, id)
+	r.mu.Unlock()
+}
+
+func (r *cancelRegistry) cancel(id string) bool {
+	r.mu.Lock()
+	cancel, ok := r.cancels[id]
+	delete(r.cancels, id)
+	r.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// requestContext derives the resolver context of a message from the deadline and request id sent by the caller.
+// The returned cancel func must always be called once the message has been handled.
+func (s *Server) requestContext(mg *messages.Message) (context.Context, context.CancelFunc) {
+	ctx, cancelTimeout := context.Background(), context.CancelFunc(func() {})
+	if v, ok := mg.Header[protocol.HeaderTimeout]; ok {
+		if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+			ctx, cancelTimeout = context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	release := func() {
+		cancel()
+		cancelTimeout()
+	}
+
+	id, ok := mg.Header[protocol.HeaderRequestID]
+	if !ok || id == empty {
+		return ctx, release
+	}
+
+	s.cancellations.add(id, cancel)
+	return ctx, func() {
+		s.cancellations.remove(id)
+		release()
+	}
+}
+
+// mountGraphCancellationSubscriber listens for callers abandoning their calls. Cancellations are broadcast to every
+// replica, as only the one handling the call knows about it. Without a NATS connection, calls run until their deadline.
+func (s *Server) mountGraphCancellationSubscriber() error {
+	if s.nc == nil {
+		return nil
+	}
+
+	root := protocol.CancelSubject(s.opts.serverName, s.opts.graphEntrypoint)
+	return s.listen(root, func(mg *messages.Message) {
+		s.cancellations.cancel(string(mg.Body))
+	})
+}
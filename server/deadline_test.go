@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// blockingExecutableSchema blocks every query until its context ends and reports how it ended.
+type blockingExecutableSchema struct {
+	started chan<- time.Time
+	ended   chan<- error
+}
+
+func (blockingExecutableSchema) Schema() *ast.Schema {
+	return replicaSchema
+}
+
+func (blockingExecutableSchema) Complexity(string, string, int, map[string]interface{}) (int, bool) {
+	return 0, false
+}
+
+func (e blockingExecutableSchema) Exec(context.Context) graphql.ResponseHandler {
+	done := false
+	return func(ctx context.Context) *graphql.Response {
+		if done {
+			return nil
+		}
+		done = true
+
+		deadline, _ := ctx.Deadline()
+		e.started <- deadline
+		<-ctx.Done()
+		e.ended <- ctx.Err()
+		return &graphql.Response{Data: json.RawMessage(`{"replica":""}`)}
+	}
+}
+
+func TestDeadlinePropagation(t *testing.T) {
+	url := runNATS(t)
+
+	started, ended := make(chan time.Time, 1), make(chan error, 1)
+	startReplica(t, url, "ms-deadlines", blockingExecutableSchema{started: started, ended: ended})
+	c := newReplicaClient(t, url, "ms-deadlines")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	clientDeadline, _ := ctx.Deadline()
+
+	go func() { _ = c.Exec(ctx, "", `{ replica }`, &struct{ Replica string }{}, nil, nil) }()
+
+	select {
+	case deadline := <-started:
+		require.False(t, deadline.IsZero(), "the resolver has no deadline")
+		require.WithinDuration(t, clientDeadline, deadline, 100*time.Millisecond)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the query never reached the resolver")
+	}
+
+	select {
+	case err := <-ended:
+		require.Equal(t, context.DeadlineExceeded, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the resolver outlived the deadline of the caller")
+	}
+}
+
+func TestRemoteCancellation(t *testing.T) {
+	url := runNATS(t)
+
+	// Whichever replica the query lands on must hear the cancellation.
+	started, ended := make(chan time.Time, 1), make(chan error, 1)
+	for i := 0; i < 3; i++ {
+		startReplica(t, url, "ms-cancellations", blockingExecutableSchema{started: started, ended: ended})
+	}
+	c := newReplicaClient(t, url, "ms-cancellations")
+
+	for i := 0; i < 5; i++ {
+		t.Run(fmt.Sprintf("call %d", i), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go func() { _ = c.Exec(ctx, "", `{ replica }`, &struct{ Replica string }{}, nil, nil) }()
+
+			select {
+			case deadline := <-started:
+				require.True(t, deadline.IsZero(), "the resolver has a deadline the caller did not set")
+			case <-time.After(5 * time.Second):
+				t.Fatal("the query never reached the resolver")
+			}
+
+			cancel()
+
+			select {
+			case err := <-ended:
+				require.Equal(t, context.Canceled, err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("the cancellation never reached the resolver")
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Just4Ease/axon/v2"
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// reply turns a NATSHandler into the handler mounted on NATS: it derives the request context, runs the NATS
+// middlewares then the handler within the concurrency limit, records traces and metrics, tracks the message as
+// in-flight and encodes errors into structured replies.
+func (s *Server) reply(handler NATSHandler) axon.ReplyHandler {
+	// Middlewares run before the message waits for a slot, so the calls they turn away, e.g. over their rate limit,
+	// neither queue nor hold a slot.
+	handler = chainNATSMiddlewares(s.opts.natsMiddlewares, s.withinConcurrencyLimit(handler))
+
+	return encodeErrors(s.trackInFlight(func(mg *messages.Message) (*messages.Message, error) {
+		ctx, cancel := s.requestContext(mg)
+		defer cancel()
+
+		// Calls are recorded from their arrival, so the wait for a slot counts and shed calls are seen too.
+		op := &operationInfo{}
+		ctx, span := s.startSpan(context.WithValue(ctx, operationInfoKey{}, op), mg)
+		start := time.Now()
+
+		res, err := handler(ctx, mg)
+		s.endSpan(span, op, err)
+		s.observe(op, mg, start, res, err)
+		return res, err
+	}))
+}
+
+// withinConcurrencyLimit runs handler once mg was handed a slot, see SetMaxConcurrency.
+func (s *Server) withinConcurrencyLimit(handler NATSHandler) NATSHandler {
+	return func(ctx context.Context, mg *messages.Message) (*messages.Message, error) {
+		release, err := s.acquireSlot(ctx, mg)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+
+		return handler(ctx, mg)
+	}
+}
+
+// encodeErrors turns errors returned by a reply handler into a protocol.Error reply,
+// so clients can tell transport failures and timeouts apart instead of receiving a bare string.
+func encodeErrors(handler axon.ReplyHandler) axon.ReplyHandler {
+	return func(mg *messages.Message) (*messages.Message, error) {
+		res, err := handler(mg)
+		if err == nil {
+			return res, nil
+		}
+
+		body, encodeErr := json.Marshal(toProtocolError(err))
+		if encodeErr != nil {
+			return nil, err
+		}
+
+		setReplyHeader(mg, protocol.HeaderError, "true")
+		return mg.WithBody(body), nil
+	}
+}
+
+func toProtocolError(err error) *protocol.Error {
+	var protocolErr *protocol.Error
+	switch {
+	case errors.As(err, &protocolErr):
+		return protocolErr
+	case errors.Is(err, context.DeadlineExceeded):
+		return &protocol.Error{Kind: protocol.ErrorKindTimeout, Status: http.StatusGatewayTimeout, Message: err.Error()}
+	case errors.Is(err, ErrServerOverloaded):
+		return &protocol.Error{Kind: protocol.ErrorKindOverloaded, Status: http.StatusServiceUnavailable, Message: err.Error()}
+	case errors.Is(err, ErrServerShuttingDown):
+		return &protocol.Error{Kind: protocol.ErrorKindTransport, Status: http.StatusServiceUnavailable, Message: err.Error()}
+	default:
+		return &protocol.Error{Kind: protocol.ErrorKindTransport, Status: http.StatusInternalServerError, Message: err.Error()}
+	}
+}
+
+// replyWithStatus sets the body of a reply along with the status code the graph handler produced.
+func replyWithStatus(mg *messages.Message, body []byte, status int) *messages.Message {
+	setReplyHeader(mg, protocol.HeaderStatus, strconv.Itoa(status))
+	return mg.WithBody(body)
+}
+
+func setReplyHeader(mg *messages.Message, key, value string) {
+	if mg.Header == nil {
+		mg.Header = make(map[string]string)
+	}
+	mg.Header[key] = value
+}
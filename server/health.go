@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/graphrpc/client"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HealthCheck reports whether a dependency of the service, e.g. its database, is usable.
+type HealthCheck func(ctx context.Context) error
+
+// RegisterHealthCheck adds a named check to the readiness of the server.
+func RegisterHealthCheck(name string, check HealthCheck) Option {
+	return func(o *Options) error {
+		if strings.TrimSpace(name) == empty || check == nil {
+			return errors.New("health check needs a name and a check func")
+		}
+
+		if o.healthChecks == nil {
+			o.healthChecks = make(map[string]HealthCheck)
+		}
+		o.healthChecks[name] = check
+		return nil
+	}
+}
+
+// SetHealthCheckTimeout sets how long health checks may run before being reported as failed.
+func SetHealthCheckTimeout(d time.Duration) Option {
+	return func(o *Options) error {
+		if d <= 0 {
+			return errors.New("health check timeout must be greater than zero")
+		}
+
+		o.healthCheckTimeout = d
+		return nil
+	}
+}
+
+// ClientHealthCheck checks that the remote service of a GraphRPC client answers on NATS.
+func ClientHealthCheck(c *client.Client) HealthCheck {
+	return func(ctx context.Context) error {
+		return c.Ping(ctx)
+	}
+}
+
+func (s *Server) isReady() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready && !s.shutdown
+}
+
+// markReady marks the server as ready once NATS acknowledged its subscribers, so requests sent from then on reach them.
+// Without a NATS connection, the subscribers of the axon.EventStore are mounted in the background and this is not
+// awaited.
+func (s *Server) markReady() error {
+	if s.nc != nil {
+		if err := s.nc.Flush(); err != nil {
+			return errors.Wrap(err, "failed to flush nats subscribers")
+		}
+	}
+
+	s.mu.Lock()
+	s.ready = true
+	s.mu.Unlock()
+	return nil
+}
+
+// health runs the registered checks concurrently and reports the readiness of the server.
+func (s *Server) health(ctx context.Context) *protocol.HealthReport {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.healthCheckTimeout)
+	defer cancel()
+
+	report := &protocol.HealthReport{Status: protocol.HealthStatusOK, Checks: make(map[string]string)}
+	if !s.isReady() {
+		report.Status = protocol.HealthStatusUnavailable
+	}
+
+	mu := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for name, check := range s.opts.healthChecks {
+		wg.Add(1)
+		go func(name string, check HealthCheck) {
+			defer wg.Done()
+
+			result := protocol.HealthStatusOK
+			if err := check(ctx); err != nil {
+				result = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if result != protocol.HealthStatusOK {
+				report.Status = protocol.HealthStatusUnavailable
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	return report
+}
+
+func (s *Server) mountHealthRoutes(router chi.Router) {
+	router.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealthReport(w, &protocol.HealthReport{Status: protocol.HealthStatusOK})
+	})
+
+	router.Get("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealthReport(w, s.health(r.Context()))
+	})
+}
+
+func writeHealthReport(w http.ResponseWriter, report *protocol.HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != protocol.HealthStatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+func (s *Server) mountHealthSubscriber() error {
+	return s.respond(protocol.HealthSubject(s.opts.serverName), func(mg *messages.Message) (*messages.Message, error) {
+		b, err := json.Marshal(s.health(context.Background()))
+		if err != nil {
+			return nil, err
+		}
+
+		return mg.WithBody(b), nil
+	})
+}
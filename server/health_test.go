@@ -0,0 +1,33 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadinessDoesNotExecuteQueries(t *testing.T) {
+	url := runNATS(t)
+
+	// A replica turning every request away, and whose queries never return, still becomes ready without running any.
+	var requests int64
+	reject := func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&requests, 1)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		})
+	}
+
+	started, ended := make(chan time.Time, 1), make(chan error, 1)
+	s := startReplica(t, url, "ms-readiness", blockingExecutableSchema{started: started, ended: ended}, UseMiddlewares(reject))
+
+	require.True(t, s.isReady())
+	require.Equal(t, protocol.HealthStatusOK, s.health(context.Background()).Status)
+	require.Zero(t, atomic.LoadInt64(&requests))
+	require.Empty(t, started)
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/Just4Ease/graphrpc/manifest"
+	"github.com/pkg/errors"
+	"github.com/vektah/gqlparser/v2/ast"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDuplicateRequest is returned by an IdempotencyStore when a call with the same idempotency key is still in flight.
+var ErrDuplicateRequest = errors.New("a call with the same idempotency key is in flight")
+
+// ErrIdempotencyKeyReused is replied to calls reusing the idempotency key of a call made with another payload.
+var ErrIdempotencyKeyReused = errors.New("the idempotency key was used by a call with another operation or variables")
+
+// IdempotentReply is the reply to a mutation, kept to be replayed to the retries of the call.
+type IdempotentReply struct {
+	// Fingerprint is a hash of the operation name, document and variables of the call, telling its retries apart
+	// from other calls mistakenly sent with the same key.
+	Fingerprint string            `json:"fingerprint"`
+	Header      map[string]string `json:"header,omitempty"`
+	Body        []byte            `json:"body"`
+}
+
+// IdempotencyStore remembers the replies of mutations by idempotency key. Implement it on top of a shared store to
+// deduplicate calls across replicas, e.g. a JetStream KV bucket with a TTL, using Create to claim keys atomically.
+type IdempotencyStore interface {
+	// Claim marks key as in flight for ttl. It returns the reply stored under key when its call completed, or
+	// ErrDuplicateRequest when the call is still in flight.
+	Claim(ctx context.Context, key string, ttl time.Duration) (*IdempotentReply, error)
+	// Complete stores the reply of the call that claimed key for ttl.
+	Complete(ctx context.Context, key string, reply *IdempotentReply, ttl time.Duration) error
+	// Release forgets key, e.g. because its call never executed the mutation.
+	Release(ctx context.Context, key string) error
+}
+
+// EnableIdempotency makes mutations carrying an idempotency key run at most once per key within window: the reply of
+// the first call that executed, graphql errors included, is replayed to later calls with the same key, and calls made
+// while it is still in flight are rejected with a retryable duplicate error. Calls that never executed, e.g. because
+// they were shed or failed validation, release their key so they can be retried. Calls whose caller gave up, e.g. on
+// a timeout, keep their key until their resolvers return, and their reply is then replayed. A key reused with another
+// operation or other variables is rejected with ErrIdempotencyKeyReused. GraphRPC clients set a key on every mutation,
+// stable across retries. The key is scoped to the calling service. Batches are not deduplicated, which is why
+// GraphRPC clients never batch mutations on their own. An in-memory store is used when store is nil, so every replica
+// only knows the calls it handled.
+func EnableIdempotency(store IdempotencyStore, window time.Duration) Option {
+	return func(o *Options) error {
+		if window <= 0 {
+			return errors.New("idempotency window must be greater than zero")
+		}
+
+		if store == nil {
+			store = NewMemoryIdempotencyStore()
+		}
+
+		o.idempotencyStore = store
+		o.idempotencyWindow = window
+		return nil
+	}
+}
+
+// idempotent wraps the handler of the graph subject, replaying the replies of mutations to calls repeating their key.
+func (s *Server) idempotent(handler NATSHandler) NATSHandler {
+	return func(ctx context.Context, mg *messages.Message) (*messages.Message, error) {
+		store := s.opts.idempotencyStore
+		key := headerValue(mg.Header, protocol.HeaderIdempotencyKey)
+		if store == nil || key == empty || protocol.IsBatch(mg.Body) {
+			return handler(ctx, mg)
+		}
+		key = fmt.Sprintf("%s:%s", mg.Source, key)
+
+		fingerprint := requestFingerprint(mg.Body)
+		reply, err := store.Claim(ctx, key, s.opts.idempotencyWindow)
+		switch {
+		case errors.Is(err, ErrDuplicateRequest):
+			return nil, &protocol.Error{Kind: protocol.ErrorKindDuplicate, Status: http.StatusConflict, Message: err.Error()}
+		case err != nil:
+			// Like the rate limiter, a failing store lets calls through.
+			log.Printf("failed to claim idempotency key %s: %v", key, err)
+			return handler(ctx, mg)
+		case reply != nil && reply.Fingerprint != fingerprint:
+			return nil, &protocol.Error{Kind: protocol.ErrorKindTransport, Status: http.StatusUnprocessableEntity, Message: ErrIdempotencyKeyReused.Error()}
+		case reply != nil:
+			return replayReply(ctx, mg, reply), nil
+		}
+
+		res, err := handler(ctx, mg)
+
+		op := operationInfoFromContext(ctx)
+		switch {
+		case executedMutation(op, res, err):
+			reply = &IdempotentReply{Fingerprint: fingerprint, Header: make(map[string]string), Body: res.Body}
+			if status, ok := res.Header[protocol.HeaderStatus]; ok {
+				reply.Header[protocol.HeaderStatus] = status
+			}
+			s.completeIdempotencyKey(key, reply)
+		case startedMutation(op):
+			// The caller gave up, e.g. on a timeout, while the resolvers still run. The key stays claimed until they
+			// return, so retries are told the call is in flight and later get its reply rather than running it twice.
+			go func() {
+				response := op.execution.wait()
+				if response == nil {
+					s.releaseIdempotencyKey(key)
+					return
+				}
+
+				body, marshalErr := json.Marshal(response)
+				if marshalErr != nil {
+					log.Printf("failed to encode the reply of idempotency key %s: %v", key, marshalErr)
+					s.releaseIdempotencyKey(key)
+					return
+				}
+				s.completeIdempotencyKey(key, &IdempotentReply{Fingerprint: fingerprint, Body: body})
+			}()
+		default:
+			// Calls that never executed the mutation, e.g. rejected by a middleware, shed or invalid, may run again.
+			s.releaseIdempotencyKey(key)
+		}
+
+		return res, err
+	}
+}
+
+func (s *Server) completeIdempotencyKey(key string, reply *IdempotentReply) {
+	if err := s.opts.idempotencyStore.Complete(context.Background(), key, reply, s.opts.idempotencyWindow); err != nil {
+		log.Printf("failed to store the reply of idempotency key %s: %v", key, err)
+	}
+}
+
+func (s *Server) releaseIdempotencyKey(key string) {
+	if err := s.opts.idempotencyStore.Release(context.Background(), key); err != nil {
+		log.Printf("failed to release idempotency key %s: %v", key, err)
+	}
+}
+
+// executedMutation reports whether a call ran a mutation to completion within its deadline, whatever graphql errors
+// its resolvers returned.
+func executedMutation(op *operationInfo, res *messages.Message, err error) bool {
+	if err != nil || res == nil || !startedMutation(op) || op.timedOut {
+		return false
+	}
+
+	status, ok := res.Header[protocol.HeaderStatus]
+	return !ok || status == strconv.Itoa(http.StatusOK)
+}
+
+// startedMutation reports whether a call dispatched a mutation to the resolvers.
+func startedMutation(op *operationInfo) bool {
+	return op != nil && op.operationType == string(ast.Mutation) && op.execution != nil
+}
+
+// requestFingerprint hashes the operation name, document and variables of a request body. The document is identified
+// by its hash, so a retry sending only the hash of a persisted query matches the call that sent the document.
+func requestFingerprint(body []byte) string {
+	params := &struct {
+		OperationName string                 `json:"operationName"`
+		Query         string                 `json:"query"`
+		Variables     map[string]interface{} `json:"variables"`
+		Extensions    struct {
+			PersistedQuery struct {
+				Sha256Hash string `json:"sha256Hash"`
+			} `json:"persistedQuery"`
+		} `json:"extensions"`
+	}{}
+	_ = json.Unmarshal(body, params)
+
+	document := params.Extensions.PersistedQuery.Sha256Hash
+	if params.Query != empty {
+		document = manifest.Hash(params.Query)
+	}
+
+	// Variables are marshalled back with their keys sorted, so their order on the wire does not matter.
+	variables, _ := json.Marshal(params.Variables)
+	return manifest.Hash(strings.Join([]string{params.OperationName, document, string(variables)}, "\x00"))
+}
+
+func replayReply(ctx context.Context, mg *messages.Message, reply *IdempotentReply) *messages.Message {
+	if op := operationInfoFromContext(ctx); op != nil {
+		params := &struct {
+			OperationName string `json:"operationName"`
+		}{}
+		_ = json.Unmarshal(mg.Body, params)
+		op.name = params.OperationName
+		op.operationType = string(ast.Mutation)
+	}
+
+	for k, v := range reply.Header {
+		setReplyHeader(mg, k, v)
+	}
+	setReplyHeader(mg, protocol.HeaderIdempotentReplay, "true")
+	return mg.WithBody(reply.Body)
+}
+
+const idempotencySweepInterval = time.Minute
+
+type idempotencyEntry struct {
+	reply   *IdempotentReply // nil while the call is in flight
+	expires time.Time
+}
+
+// memoryIdempotencyStore keeps idempotency keys in memory, so every replica only deduplicates the calls it handled.
+type memoryIdempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+}
+
+// NewMemoryIdempotencyStore returns an IdempotencyStore keeping the keys in the memory of this replica.
+func NewMemoryIdempotencyStore() IdempotencyStore {
+	return &memoryIdempotencyStore{entries: make(map[string]*idempotencyEntry), lastSweep: time.Now()}
+}
+
+func (s *memoryIdempotencyStore) Claim(_ context.Context, key string, ttl time.Duration) (*IdempotentReply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	if entry, ok := s.entries[key]; ok && now.Before(entry.expires) {
+		if entry.reply == nil {
+			return nil, ErrDuplicateRequest
+		}
+		return entry.reply, nil
+	}
+
+	s.entries[key] = &idempotencyEntry{expires: now.Add(ttl)}
+	return nil, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(_ context.Context, key string, reply *IdempotentReply, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = &idempotencyEntry{reply: reply, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// sweep forgets the keys whose window has passed.
+func (s *memoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < idempotencySweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, entry := range s.entries {
+		if now.After(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/axon/v2/options"
+	"github.com/Just4Ease/axon/v2/systems/jetstream"
+	"github.com/Just4Ease/graphrpc/client"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+var mutationSchema = gqlparser.MustLoadSchema(&ast.Source{Input: `
+	type Query { replica: String! }
+	type Mutation { replica: String! }
+`})
+
+func TestIdempotency(t *testing.T) {
+	url := runNATS(t)
+
+	var handled, inFlight, maxSeen int64
+	schema := replicaExecutableSchema{schema: mutationSchema, name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen}
+	startReplica(t, url, "ms-idempotent", schema, EnableIdempotency(nil, time.Minute))
+
+	c := newReplicaClient(t, url, "ms-idempotent")
+	mutate := func(ctx context.Context) error {
+		res := &struct{ Replica string }{}
+		err := c.Exec(ctx, "Replicate", `mutation Replicate { replica }`, res, nil, nil)
+		if err == nil {
+			require.Equal(t, "replica", res.Replica)
+		}
+		return err
+	}
+
+	ctx := client.WithIdempotencyKey(context.Background(), "order-1")
+	require.NoError(t, mutate(ctx))
+	require.NoError(t, mutate(ctx))
+	require.Equal(t, int64(1), handled)
+
+	// Every call made without a key of its own gets a new one.
+	require.NoError(t, mutate(context.Background()))
+	require.NoError(t, mutate(context.Background()))
+	require.Equal(t, int64(3), handled)
+}
+
+func TestIdempotencyRejectsConcurrentDuplicates(t *testing.T) {
+	url := runNATS(t)
+
+	var handled, inFlight, maxSeen int64
+	schema := replicaExecutableSchema{schema: mutationSchema, name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen, delay: 200 * time.Millisecond}
+	startReplica(t, url, "ms-idempotent-slow", schema, EnableIdempotency(nil, time.Minute), SetMaxConcurrency(2))
+	c := newReplicaClient(t, url, "ms-idempotent-slow")
+
+	ctx := client.WithIdempotencyKey(context.Background(), "order-1")
+	mutate := func() error {
+		return c.Exec(ctx, "Replicate", `mutation Replicate { replica }`, &struct{ Replica string }{}, nil, nil)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- mutate() }()
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&inFlight) == 1 }, 5*time.Second, time.Millisecond)
+
+	err := mutate()
+	var duplicateErr *client.DuplicateRequestError
+	require.True(t, errors.As(err, &duplicateErr), "unexpected error: %v", err)
+	require.True(t, client.IsRetryable(err))
+	require.NoError(t, <-done)
+
+	// Once the first call completed, its reply is replayed.
+	require.NoError(t, mutate())
+	require.Equal(t, int64(1), atomic.LoadInt64(&handled))
+}
+
+func TestIdempotencyRejectsReusedKeys(t *testing.T) {
+	url := runNATS(t)
+
+	schema := gqlparser.MustLoadSchema(&ast.Source{Input: `
+		type Query { replica: String! }
+		type Mutation { replica(id: ID): String! }
+	`})
+
+	var handled, inFlight, maxSeen int64
+	startReplica(t, url, "ms-idempotent-reuse", replicaExecutableSchema{schema: schema, name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen},
+		EnableIdempotency(nil, time.Minute))
+	c := newReplicaClient(t, url, "ms-idempotent-reuse")
+
+	ctx := client.WithIdempotencyKey(context.Background(), "order-1")
+	mutate := func(id string) error {
+		return c.Exec(ctx, "Replicate", `mutation Replicate($id: ID) { replica(id: $id) }`, &struct{ Replica string }{}, map[string]interface{}{"id": id}, nil)
+	}
+
+	require.NoError(t, mutate("1"))
+	require.NoError(t, mutate("1"))
+
+	err := mutate("2")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), ErrIdempotencyKeyReused.Error())
+	require.False(t, client.IsRetryable(err))
+	require.Equal(t, int64(1), handled)
+}
+
+func TestIdempotencyKeepsCallsPastTheirDeadline(t *testing.T) {
+	url := runNATS(t)
+
+	var handled, inFlight, maxSeen int64
+	schema := replicaExecutableSchema{schema: mutationSchema, name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen, delay: 300 * time.Millisecond}
+	startReplica(t, url, "ms-idempotent-timeout", schema, EnableIdempotency(nil, time.Minute))
+	c := newReplicaClient(t, url, "ms-idempotent-timeout")
+
+	mutate := func(timeout time.Duration) (*struct{ Replica string }, error) {
+		ctx, cancel := context.WithTimeout(client.WithIdempotencyKey(context.Background(), "order-1"), timeout)
+		defer cancel()
+		res := &struct{ Replica string }{}
+		return res, c.Exec(ctx, "Replicate", `mutation Replicate { replica }`, res, nil, nil)
+	}
+
+	// The call runs past its deadline while its resolver carries on, so its retry is told the call is in flight.
+	var timeoutErr *client.TimeoutError
+	_, err := mutate(50 * time.Millisecond)
+	require.True(t, errors.As(err, &timeoutErr))
+
+	var duplicateErr *client.DuplicateRequestError
+	_, err = mutate(time.Second)
+	require.True(t, errors.As(err, &duplicateErr))
+
+	// Once the resolver returned, retries get its reply without running the mutation again.
+	require.Eventually(t, func() bool {
+		res, err := mutate(time.Second)
+		return err == nil && res.Replica == "replica"
+	}, 5*time.Second, 50*time.Millisecond)
+	require.Equal(t, int64(1), atomic.LoadInt64(&handled))
+}
+
+func TestIdempotencyReleasesCallsThatNeverExecuted(t *testing.T) {
+	url := runNATS(t)
+
+	var handled, inFlight, maxSeen int64
+	schema := replicaExecutableSchema{schema: mutationSchema, name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen}
+	startReplica(t, url, "ms-idempotent-apq", schema, EnableIdempotency(nil, time.Minute), EnableAutomaticPersistedQueries(nil))
+
+	store, err := jetstream.Init(options.Options{ServiceName: "ms-idempotent-apq-client", Address: url})
+	require.NoError(t, err)
+	t.Cleanup(store.Close)
+
+	c, err := client.NewClient(store, client.SetRemoteServiceName("ms-idempotent-apq"), client.SetRemoteGraphQLPath("graph"), client.EnableAutomaticPersistedQueries())
+	require.NoError(t, err)
+
+	// The hash of the document is unknown, so the first attempt releases its key for the one sending the document.
+	ctx := client.WithIdempotencyKey(context.Background(), "order-1")
+	require.NoError(t, c.Exec(ctx, "Replicate", `mutation Replicate { replica }`, &struct{ Replica string }{}, nil, nil))
+	require.Equal(t, int64(1), atomic.LoadInt64(&handled))
+}
+
+func TestIdempotencySkipsBatches(t *testing.T) {
+	t.Parallel()
+	s := &Server{opts: &Options{idempotencyStore: NewMemoryIdempotencyStore(), idempotencyWindow: time.Minute}}
+
+	var handled int
+	handler := s.idempotent(func(ctx context.Context, mg *messages.Message) (*messages.Message, error) {
+		handled++
+		return mg, nil
+	})
+
+	for i := 0; i < 2; i++ {
+		mg := messages.NewMessage().WithBody([]byte(`[{"query":"mutation Replicate { replica }"},{"query":"mutation Replicate { replica }"}]`))
+		mg.Header = map[string]string{protocol.HeaderIdempotencyKey: "order-1"}
+		_, err := handler(context.Background(), mg)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 2, handled)
+}
+
+func TestClientNeverBatchesMutations(t *testing.T) {
+	url := runNATS(t)
+
+	var handled, inFlight, maxSeen, batches int64
+	schema := replicaExecutableSchema{schema: mutationSchema, name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen}
+	startReplica(t, url, "ms-idempotent-batching", schema, EnableIdempotency(nil, time.Minute), UseNATSMiddlewares(countMessages(&batches)))
+
+	store, err := jetstream.Init(options.Options{ServiceName: "ms-idempotent-batching-client", Address: url})
+	require.NoError(t, err)
+	t.Cleanup(store.Close)
+
+	c, err := client.NewClient(store, client.SetRemoteServiceName("ms-idempotent-batching"), client.SetRemoteGraphQLPath("graph"), client.EnableBatching(100*time.Millisecond, 5))
+	require.NoError(t, err)
+
+	ctx := client.WithIdempotencyKey(context.Background(), "order-1")
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				err := c.Exec(ctx, "Replicate", `mutation Replicate { replica }`, &struct{ Replica string }{}, nil, nil)
+				if !client.IsRetryable(err) {
+					require.NoError(t, err)
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Both calls share a key: sent on their own, the second one is deduplicated rather than batched with the first.
+	require.Zero(t, atomic.LoadInt64(&batches))
+	require.Equal(t, int64(1), atomic.LoadInt64(&handled))
+}
@@ -1,22 +1,17 @@
 package server
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/Just4Ease/axon/v2/messages"
 	"github.com/pkg/errors"
-	"io/ioutil"
-	goLog "log"
-	"net/http"
 )
 
-func (s *Server) mountGraphIntrospectionSubscriber() {
+func (s *Server) mountGraphIntrospectionSubscriber() error {
 	root := fmt.Sprintf("%s.introspect", s.opts.serverName)
 
-	endpoint := fmt.Sprintf("http://%s/%s", s.graphListener.Addr().String(), s.opts.graphEntrypoint)
-
-	if err := s.axonClient.Reply(root, func(mg *messages.Message) (*messages.Message, error) {
+	return s.respond(root, s.reply(func(ctx context.Context, mg *messages.Message) (*messages.Message, error) {
 		type Body struct {
 			Query     string                 `json:"query"`
 			Variables map[string]interface{} `json:"variables"`
@@ -28,26 +23,17 @@ func (s *Server) mountGraphIntrospectionSubscriber() {
 		}
 
 		marsh, _ := json.Marshal(payload)
-		r, err := http.Post(endpoint, "application/json", bytes.NewReader(marsh))
+		res, err := s.execute(ctx, marsh, mg.Header)
 		if err != nil {
 			return nil, err
 		}
 
-		body, err := ioutil.ReadAll(r.Body)
-		if len(body) != 0 {
-			return mg.WithBody(body), nil
-		}
-
-		if err != nil {
-			return nil, err
+		if res.body.Len() != 0 {
+			return replyWithStatus(mg, res.body.Bytes(), res.code), nil
 		}
 
 		return nil, errors.New("internal server error")
-	}); err != nil {
-		goLog.Fatal(err)
-	}
-
-	<-make(chan bool)
+	}))
 }
 
 const IntrospectionQuery = `
@@ -55,6 +41,7 @@ const IntrospectionQuery = `
     __schema {
       queryType { name }
       mutationType { name }
+      subscriptionType { name }
       types {
         ...FullType
       }
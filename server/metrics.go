@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/graphrpc/internal/metrics"
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"time"
+)
+
+// MetricsRegistry is where the server registers its collectors and what /metrics exposes, e.g. a *prometheus.Registry.
+type MetricsRegistry interface {
+	prometheus.Registerer
+	prometheus.Gatherer
+}
+
+// EnableMetrics records Prometheus metrics for every call handled over NATS and exposes them on /metrics.
+// The default Prometheus registry is used when registry is nil.
+func EnableMetrics(registry MetricsRegistry) Option {
+	return func(o *Options) error {
+		if registry == nil {
+			registry = defaultMetricsRegistry{Registerer: prometheus.DefaultRegisterer, Gatherer: prometheus.DefaultGatherer}
+		}
+
+		o.metricsRegistry = registry
+		return nil
+	}
+}
+
+type defaultMetricsRegistry struct {
+	prometheus.Registerer
+	prometheus.Gatherer
+}
+
+type operationInfoKey struct{}
+
+// operationInfo is filled in by natsTransport once it knows which operation a message carries.
+type operationInfo struct {
+	name          string
+	operationType string
+	graphErrors   bool
+	timeout       time.Duration // execution timeout applied to the operation
+	timedOut      bool          // the operation ran past its timeout
+	execution     *execution    // the operation dispatched to the resolvers, nil when it never executed
+}
+
+func operationInfoFromContext(ctx context.Context) *operationInfo {
+	op, _ := ctx.Value(operationInfoKey{}).(*operationInfo)
+	return op
+}
+
+// observe records a call handled over NATS. Messages that did not execute an operation, e.g. malformed ones,
+// are only recorded when they fail.
+func (s *Server) observe(op *operationInfo, mg *messages.Message, start time.Time, res *messages.Message, err error) {
+	if s.metrics == nil {
+		return
+	}
+
+	observation := metrics.Observation{
+		Service:       s.opts.serverName,
+		Operation:     op.name,
+		OperationType: op.operationType,
+		Duration:      time.Since(start),
+		RequestSize:   len(mg.Body),
+	}
+
+	switch {
+	case err != nil:
+		observation.ErrorKind = string(toProtocolError(err).Kind)
+	case op.graphErrors:
+		observation.ErrorKind = metrics.ErrorKindGraphQL
+	case op.operationType == empty:
+		return
+	}
+
+	if res != nil {
+		observation.ResponseSize = len(res.Body)
+	}
+
+	s.metrics.Observe(observation)
+}
+
+func (s *Server) mountMetricsRoute(router chi.Router) {
+	if s.opts.metricsRegistry == nil {
+		return
+	}
+
+	router.Handle("/metrics", promhttp.HandlerFor(s.opts.metricsRegistry, promhttp.HandlerOpts{}))
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/pkg/errors"
+)
+
+// NATSHandler handles a message that arrived over NATS and returns the reply to send back.
+// ctx is passed down to the resolvers, so values set on it by middlewares are visible to them.
+type NATSHandler func(ctx context.Context, mg *messages.Message) (*messages.Message, error)
+
+// NATSMiddleware wraps the handling of every message arriving over NATS. It sees the raw message, including its
+// subject, headers and body, before calling next and the reply or error after it, which makes it the place for
+// auth, logging, quotas and tracing of NATS traffic.
+type NATSMiddleware func(next NATSHandler) NATSHandler
+
+// UseNATSMiddlewares registers middlewares for messages arriving over NATS. The first middleware is the outermost.
+// Middlewares run before messages wait for a handler slot, see SetMaxConcurrency.
+func UseNATSMiddlewares(middlewares ...NATSMiddleware) Option {
+	return func(o *Options) error {
+		for _, middleware := range middlewares {
+			if middleware == nil {
+				return errors.New("cannot use nil as nats middleware")
+			}
+		}
+
+		o.natsMiddlewares = append(o.natsMiddlewares, middlewares...)
+		return nil
+	}
+}
+
+func chainNATSMiddlewares(middlewares []NATSMiddleware, handler NATSHandler) NATSHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return handler
+}
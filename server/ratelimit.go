@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket: Burst calls may be made at once, refilled at Rate calls per second.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitStore keeps the token buckets of a rate limiter. Implement it on top of a shared store, e.g. Redis or a
+// NATS KV bucket, to enforce a limit across replicas.
+type RateLimitStore interface {
+	// Take removes a token from the bucket of key. When the bucket is empty it returns false and how long until the
+	// next token is available.
+	Take(ctx context.Context, key string, limit RateLimit) (bool, time.Duration, error)
+}
+
+// RateLimitKey extracts one dimension of the key a message is rate limited by.
+type RateLimitKey func(mg *messages.Message) string
+
+// RateLimitByCaller keys the rate limit by the service name of the caller.
+func RateLimitByCaller() RateLimitKey {
+	return func(mg *messages.Message) string {
+		return mg.Source
+	}
+}
+
+// RateLimitByHeader keys the rate limit by the value of a header, e.g. an API key.
+func RateLimitByHeader(name string) RateLimitKey {
+	return func(mg *messages.Message) string {
+		return mg.Header[name]
+	}
+}
+
+// RateLimitByOperation keys the rate limit by the name of the operation called. The operations of a batch are keyed
+// by their own names.
+func RateLimitByOperation() RateLimitKey {
+	return func(mg *messages.Message) string {
+		name, _ := rateLimitedOperation(mg)
+		return name
+	}
+}
+
+// RateLimiter returns a NATSMiddleware limiting calls to limit per key, where the key is made of the given dimensions.
+// Calls over the limit are replied a rate limited error carrying how long to wait, before waiting for a handler slot.
+// Every GraphQL request and subscription start takes a token, other subscription frames are not limited. A batch takes a token per operation it carries and is rejected as a whole
+// once one of them is over the limit, the tokens taken by the others being spent. If the store fails, calls are let
+// through.
+func RateLimiter(limit RateLimit, store RateLimitStore, keys ...RateLimitKey) NATSMiddleware {
+	if store == nil {
+		store = NewMemoryRateLimitStore()
+	}
+
+	return func(next NATSHandler) NATSHandler {
+		return func(ctx context.Context, mg *messages.Message) (*messages.Message, error) {
+			for _, operation := range rateLimitedMessages(mg) {
+				parts := make([]string, len(keys))
+				for i, key := range keys {
+					parts[i] = key(operation)
+				}
+				key := strings.Join(parts, "|")
+
+				ok, retryAfter, err := store.Take(ctx, key, limit)
+				if err != nil || ok {
+					continue
+				}
+
+				return nil, &protocol.Error{
+					Kind:       protocol.ErrorKindRateLimited,
+					Status:     http.StatusTooManyRequests,
+					Message:    fmt.Sprintf("rate limit exceeded, retry after %s", retryAfter),
+					RetryAfter: retryAfter.Milliseconds(),
+				}
+			}
+
+			return next(ctx, mg)
+		}
+	}
+}
+
+// rateLimitedMessages returns a message per operation mg starts: mg itself, a copy of it per operation of a batch,
+// or none for subscription frames other than protocol.FrameStart.
+func rateLimitedMessages(mg *messages.Message) []*messages.Message {
+	if !protocol.IsBatch(mg.Body) {
+		if _, limited := rateLimitedOperation(mg); !limited {
+			return nil
+		}
+		return []*messages.Message{mg}
+	}
+
+	requests := make([]json.RawMessage, 0)
+	if err := json.Unmarshal(mg.Body, &requests); err != nil || len(requests) == 0 {
+		// Malformed and empty batches are charged as a single call.
+		return []*messages.Message{mg}
+	}
+
+	operations := make([]*messages.Message, len(requests))
+	for i, request := range requests {
+		operation := *mg
+		operation.Body = request
+		operations[i] = &operation
+	}
+	return operations
+}
+
+// rateLimitedOperation returns the operation name of a message and whether it starts an operation, i.e. it is a
+// GraphQL request or the start of a subscription.
+func rateLimitedOperation(mg *messages.Message) (string, bool) {
+	body := &struct {
+		OperationName string             `json:"operationName"`
+		Type          protocol.FrameType `json:"type"`
+		Payload       json.RawMessage    `json:"payload"`
+	}{}
+	_ = json.Unmarshal(mg.Body, body)
+
+	switch body.Type {
+	case empty:
+		return body.OperationName, true
+	case protocol.FrameStart:
+		_ = json.Unmarshal(body.Payload, body)
+		return body.OperationName, true
+	default:
+		return empty, false
+	}
+}
+
+const rateLimitSweepInterval = time.Minute
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// memoryRateLimitStore keeps token buckets in memory, so every replica enforces the limit on its own.
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// NewMemoryRateLimitStore returns a RateLimitStore keeping the buckets in the memory of this replica.
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{buckets: make(map[string]*tokenBucket), lastSweep: time.Now()}
+}
+
+func (s *memoryRateLimitStore) Take(_ context.Context, key string, limit RateLimit) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now, limit)
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(float64(limit.Burst), bucket.tokens+now.Sub(bucket.last).Seconds()*limit.Rate)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0, nil
+	}
+
+	if limit.Rate <= 0 {
+		return false, rateLimitSweepInterval, nil
+	}
+
+	return false, time.Duration((1 - bucket.tokens) / limit.Rate * float64(time.Second)), nil
+}
+
+// sweep forgets the buckets that have refilled, as they are no different from new ones.
+func (s *memoryRateLimitStore) sweep(now time.Time, limit RateLimit) {
+	if now.Sub(s.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, bucket := range s.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*limit.Rate >= float64(limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
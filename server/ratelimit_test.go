@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/graphrpc/client"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	t.Parallel()
+	store := NewMemoryRateLimitStore()
+	limit := RateLimit{Rate: 10, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		ok, _, err := store.Take(context.Background(), "batch-job", limit)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+
+	ok, retryAfter, err := store.Take(context.Background(), "batch-job", limit)
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.InDelta(t, float64(100*time.Millisecond), float64(retryAfter), float64(5*time.Millisecond))
+
+	// Other keys have buckets of their own.
+	ok, _, err = store.Take(context.Background(), "gateway", limit)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+	handler := RateLimiter(RateLimit{Rate: 1, Burst: 1}, nil, RateLimitByCaller(), RateLimitByOperation())(
+		func(ctx context.Context, mg *messages.Message) (*messages.Message, error) {
+			return mg, nil
+		},
+	)
+
+	call := func(source string, body string) error {
+		mg := messages.NewMessage().WithBody([]byte(body))
+		mg.Source = source
+		_, err := handler(context.Background(), mg)
+		return err
+	}
+
+	require.NoError(t, call("batch-job", `{"operationName":"GetUser","query":"query GetUser { user { id } }"}`))
+
+	err := call("batch-job", `{"operationName":"GetUser","query":"query GetUser { user { id } }"}`)
+	var protocolErr *protocol.Error
+	require.True(t, errors.As(err, &protocolErr))
+	require.Equal(t, protocol.ErrorKindRateLimited, protocolErr.Kind)
+	require.Equal(t, 429, protocolErr.Status)
+	require.Greater(t, protocolErr.RetryAfter, int64(0))
+
+	// Another operation, another caller and subscription polls are not limited by the same bucket.
+	require.NoError(t, call("batch-job", `{"operationName":"ListUsers","query":"query ListUsers { users { id } }"}`))
+	require.NoError(t, call("gateway", `{"operationName":"GetUser","query":"query GetUser { user { id } }"}`))
+	require.NoError(t, call("batch-job", `{"type":"next","id":"sub-1"}`))
+}
+
+func TestRateLimiterChargesBatchOperations(t *testing.T) {
+	t.Parallel()
+	var handled int
+	handler := RateLimiter(RateLimit{Rate: 0.001, Burst: 3}, nil, RateLimitByCaller(), RateLimitByOperation())(
+		func(ctx context.Context, mg *messages.Message) (*messages.Message, error) {
+			handled++
+			return mg, nil
+		},
+	)
+
+	call := func(body string) error {
+		mg := messages.NewMessage().WithBody([]byte(body))
+		mg.Source = "batch-job"
+		_, err := handler(context.Background(), mg)
+		return err
+	}
+
+	getUser := `{"operationName":"GetUser","query":"query GetUser { user { id } }"}`
+	listUsers := `{"operationName":"ListUsers","query":"query ListUsers { users { id } }"}`
+
+	// A batch takes a token per operation from the bucket of each operation.
+	require.NoError(t, call("["+getUser+","+getUser+","+listUsers+"]"))
+	require.NoError(t, call(getUser))
+
+	err := call("[" + getUser + "," + listUsers + "]")
+	var protocolErr *protocol.Error
+	require.True(t, errors.As(err, &protocolErr))
+	require.Equal(t, protocol.ErrorKindRateLimited, protocolErr.Kind)
+	require.Equal(t, 2, handled)
+
+	require.NoError(t, call(listUsers))
+}
+
+func TestRateLimitIsCheckedBeforeQueueing(t *testing.T) {
+	url := runNATS(t)
+
+	var handled, inFlight, maxSeen int64
+	schema := replicaExecutableSchema{name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen, delay: 300 * time.Millisecond}
+	s := startReplica(t, url, "ms-rate-limited", schema, SetMaxConcurrency(1), SetMaxQueue(1),
+		UseNATSMiddlewares(RateLimiter(RateLimit{Rate: 0.001, Burst: 1}, nil, RateLimitByHeader("X-Api-Key"))))
+	c := newReplicaClient(t, url, "ms-rate-limited")
+
+	exec := func(apiKey string) error {
+		return c.Exec(context.Background(), "", `{ replica }`, &struct{ Replica string }{}, nil, client.Header{"X-Api-Key": apiKey})
+	}
+
+	// Legitimate calls take the only slot and the only place in the queue.
+	done := make(chan error, 2)
+	go func() { done <- exec("first") }()
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&inFlight) == 1 }, 5*time.Second, time.Millisecond)
+	go func() { done <- exec("second") }()
+	require.Eventually(t, func() bool {
+		s.limiter.mu.Lock()
+		defer s.limiter.mu.Unlock()
+		return len(s.limiter.queues[0]) == 1
+	}, 5*time.Second, time.Millisecond)
+
+	// Another caller spends its token on a shed call, then is turned away straight away instead of queueing.
+	var overloadedErr *client.OverloadedError
+	require.True(t, errors.As(exec("third"), &overloadedErr))
+	for i := 0; i < 5; i++ {
+		start := time.Now()
+		err := exec("third")
+
+		var rateLimitErr *client.RateLimitError
+		require.True(t, errors.As(err, &rateLimitErr), "unexpected error: %v", err)
+		require.Less(t, int64(time.Since(start)), int64(200*time.Millisecond))
+	}
+
+	require.NoError(t, <-done)
+	require.NoError(t, <-done)
+	require.Equal(t, int64(2), atomic.LoadInt64(&handled))
+}
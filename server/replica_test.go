@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/Just4Ease/axon/v2/options"
+	"github.com/Just4Ease/axon/v2/systems/jetstream"
+	"github.com/Just4Ease/graphrpc/client"
+	natsServer "github.com/nats-io/nats-server/v2/server"
+	natsTest "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+var replicaSchema = gqlparser.MustLoadSchema(&ast.Source{Input: `type Query { replica: String! }`})
+
+// replicaExecutableSchema answers every query with the name of the replica executing it and counts its executions.
+type replicaExecutableSchema struct {
+	schema   *ast.Schema
+	name     string
+	handled  *int64
+	inFlight *int64
+	maxSeen  *int64
+	delay    time.Duration
+}
+
+func (e replicaExecutableSchema) Schema() *ast.Schema {
+	if e.schema != nil {
+		return e.schema
+	}
+	return replicaSchema
+}
+
+func (e replicaExecutableSchema) Complexity(string, string, int, map[string]interface{}) (int, bool) {
+	return 0, false
+}
+
+func (e replicaExecutableSchema) Exec(context.Context) graphql.ResponseHandler {
+	done := false
+	return func(ctx context.Context) *graphql.Response {
+		if done {
+			return nil
+		}
+		done = true
+
+		// Typename queries only check the replica is reachable and are not part of the load.
+		if !strings.Contains(graphql.GetOperationContext(ctx).RawQuery, "replica") {
+			return &graphql.Response{Data: json.RawMessage(`{"__typename":"Query"}`)}
+		}
+
+		n := atomic.AddInt64(e.inFlight, 1)
+		defer atomic.AddInt64(e.inFlight, -1)
+		for {
+			max := atomic.LoadInt64(e.maxSeen)
+			if n <= max || atomic.CompareAndSwapInt64(e.maxSeen, max, n) {
+				break
+			}
+		}
+
+		time.Sleep(e.delay)
+		atomic.AddInt64(e.handled, 1)
+		return &graphql.Response{Data: json.RawMessage(fmt.Sprintf(`{"replica":%q}`, e.name))}
+	}
+}
+
+func runNATS(t *testing.T) string {
+	opts := natsTest.DefaultTestOptions
+	opts.Port = natsServer.RANDOM_PORT
+	ns := natsTest.RunServer(&opts)
+	t.Cleanup(ns.Shutdown)
+	return ns.ClientURL()
+}
+
+// connectNATS returns a NATS connection to url, closed once the test is over.
+func connectNATS(t *testing.T, url string) *nats.Conn {
+	nc, err := nats.Connect(url)
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func startReplica(t *testing.T, url, serviceName string, schema graphql.ExecutableSchema, opts ...Option) *Server {
+	store, err := jetstream.Init(options.Options{ServiceName: serviceName, Address: url})
+	require.NoError(t, err)
+
+	opts = append([]Option{UseNATSConnection(connectNATS(t, url))}, opts...)
+	s := NewServer(store, handler.New(schema), append(opts, DisableGraphHTTPServer())...)
+	go func() { _ = s.Serve() }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = s.Shutdown(ctx)
+	})
+
+	require.Eventually(t, s.isReady, 5*time.Second, 10*time.Millisecond)
+	return s
+}
+
+func newReplicaClient(t *testing.T, url, serviceName string) *client.Client {
+	store, err := jetstream.Init(options.Options{ServiceName: serviceName + "-client", Address: url})
+	require.NoError(t, err)
+	t.Cleanup(store.Close)
+
+	c, err := client.NewClient(store, client.SetRemoteServiceName(serviceName), client.SetRemoteGraphQLPath("graph"), client.UseNATSConnection(connectNATS(t, url)))
+	require.NoError(t, err)
+	return c
+}
+
+func TestReplicasShareLoad(t *testing.T) {
+	url := runNATS(t)
+	const (
+		replicas = 3
+		requests = 300
+	)
+
+	handled := make([]int64, replicas)
+	var inFlight, maxSeen int64
+	for i := 0; i < replicas; i++ {
+		schema := replicaExecutableSchema{name: fmt.Sprintf("replica-%d", i), handled: &handled[i], inFlight: &inFlight, maxSeen: &maxSeen}
+		startReplica(t, url, "ms-replicas", schema, SetQueueGroup("ms-replicas"))
+	}
+
+	c := newReplicaClient(t, url, "ms-replicas")
+	answeredBy := make(map[string]int)
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := &struct{ Replica string }{}
+			require.NoError(t, c.Exec(context.Background(), "", `{ replica }`, res, nil, nil))
+
+			mu.Lock()
+			answeredBy[res.Replica]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	total := int64(0)
+	for i := 0; i < replicas; i++ {
+		name := fmt.Sprintf("replica-%d", i)
+		require.NotZero(t, handled[i], "%s received no requests", name)
+		require.Equal(t, int64(answeredBy[name]), handled[i])
+		total += handled[i]
+	}
+
+	// Every request is executed by exactly one replica.
+	require.Equal(t, int64(requests), total)
+}
+
+func TestQueueGroup(t *testing.T) {
+	url := runNATS(t)
+
+	// Each group receives its own copy of every request.
+	var blue, green, inFlight, maxSeen int64
+	s := startReplica(t, url, "ms-groups", replicaExecutableSchema{name: "blue", handled: &blue, inFlight: &inFlight, maxSeen: &maxSeen}, SetQueueGroup("blue"))
+	require.Equal(t, "blue", s.QueueGroup())
+	startReplica(t, url, "ms-groups", replicaExecutableSchema{name: "green", handled: &green, inFlight: &inFlight, maxSeen: &maxSeen}, SetQueueGroup("green"))
+
+	c := newReplicaClient(t, url, "ms-groups")
+	for i := 0; i < 10; i++ {
+		require.NoError(t, c.Exec(context.Background(), "", `{ replica }`, &struct{ Replica string }{}, nil, nil))
+	}
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&blue) == 10 && atomic.LoadInt64(&green) == 10
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	url := runNATS(t)
+
+	var handled, inFlight, maxSeen int64
+	schema := replicaExecutableSchema{name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen, delay: 50 * time.Millisecond}
+	s := startReplica(t, url, "ms-limited", schema, SetMaxConcurrency(3))
+	require.Equal(t, "ms-limited", s.QueueGroup())
+
+	c := newReplicaClient(t, url, "ms-limited")
+
+	// Queries on the same subject run side by side, up to the limit.
+	wg := sync.WaitGroup{}
+	start := time.Now()
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, c.Exec(context.Background(), "", `{ replica }`, &struct{ Replica string }{}, nil, nil))
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int64(12), handled)
+	require.Equal(t, int64(3), maxSeen)
+	require.Less(t, int64(time.Since(start)), int64(12*50*time.Millisecond))
+}
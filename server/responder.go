@@ -0,0 +1,169 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Just4Ease/axon/v2"
+	"github.com/Just4Ease/axon/v2/codec/msgpack"
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"log"
+	"time"
+)
+
+// messageSpecVersion is the spec version axon requests are sent with unless the caller picks another one.
+const messageSpecVersion = "default"
+
+// responderContentType is the content type axon stamps on replies.
+const responderContentType = "application/json"
+
+var messageCodec = msgpack.Marshaler{}
+
+// ErrNATSConnectionRequired is returned by the features that need the NATS connection given to UseNATSConnection.
+var ErrNATSConnectionRequired = errors.New("a NATS connection is required, see UseNATSConnection")
+
+// UseNATSConnection serves the graph on nc, usually the connection the axon.EventStore was created with. The server
+// neither owns nor closes it.
+//
+// axon.EventStore only offers queue groups named after the service, handled one message at a time and never
+// unsubscribed. Without a connection, requests are served through EventStore.Reply: SetQueueGroup,
+// SetMaxConcurrency and draining on Shutdown have no effect, callers cannot cancel calls early and subscriptions are
+// rejected with ErrNATSConnectionRequired.
+func UseNATSConnection(nc *nats.Conn) Option {
+	return func(o *Options) error {
+		if nc == nil {
+			return errors.New("cannot use nil as nats connection")
+		}
+
+		o.natsConn = nc
+		return nil
+	}
+}
+
+// respond mounts handler on subject, answering requests sent with axon's EventStore.Request just like
+// EventStore.Reply would, but on the server's own NATS subscription so Shutdown can drain it and under the queue
+// group of the server.
+func (s *Server) respond(subject string, handler axon.ReplyHandler) error {
+	if s.nc == nil {
+		// EventStore.Reply blocks for as long as it serves subject.
+		go func() {
+			if err := s.axonClient.Reply(subject, handler); err != nil {
+				log.Printf("failed to reply on %s: %v", subject, err)
+			}
+		}()
+		return nil
+	}
+
+	sub, err := s.nc.QueueSubscribe(fmt.Sprintf("%s-%s", subject, messageSpecVersion), s.QueueGroup(), func(msg *nats.Msg) {
+		s.dispatch(func() { s.handleRequest(subject, msg, handler) })
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to subscribe to %s", subject)
+	}
+
+	s.addSubscriber(sub)
+	return nil
+}
+
+// listen mounts handler on subject on every replica. Unlike respond, no queue group is involved and nothing is
+// replied, which suits broadcasts published with axon's EventStore.Publish.
+func (s *Server) listen(subject string, handler func(mg *messages.Message)) error {
+	if s.nc == nil {
+		return ErrNATSConnectionRequired
+	}
+
+	sub, err := s.nc.Subscribe(fmt.Sprintf("%s-%s", subject, messageSpecVersion), func(msg *nats.Msg) {
+		var mg messages.Message
+		if err := messageCodec.Unmarshal(msg.Data, &mg); err != nil {
+			log.Printf("failed to decode message on %s: %v", subject, err)
+			return
+		}
+
+		handler(&mg)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to subscribe to %s", subject)
+	}
+
+	s.addSubscriber(sub)
+	return nil
+}
+
+func (s *Server) addSubscriber(sub *nats.Subscription) {
+	s.mu.Lock()
+	s.subscribers = append(s.subscribers, sub)
+	s.mu.Unlock()
+}
+
+func (s *Server) handleRequest(subject string, msg *nats.Msg, handler axon.ReplyHandler) {
+	var mg messages.Message
+	if err := messageCodec.Unmarshal(msg.Data, &mg); err != nil {
+		log.Printf("failed to decode request on %s: %v", subject, err)
+		return
+	}
+
+	res, err := handler(&mg)
+	if err != nil {
+		res = messages.NewMessage()
+		res.Error = err.Error()
+		res.WithType(messages.ErrorMessage)
+	} else {
+		res.WithType(messages.ResponseMessage)
+	}
+	res.WithSpecVersion(mg.SpecVersion)
+	res.WithSource(s.axonClient.GetServiceName())
+	res.WithSubject(subject)
+	res.WithContentType(responderContentType)
+
+	data, err := messageCodec.Marshal(res)
+	if err != nil {
+		log.Printf("failed to encode reply on %s: %v", subject, err)
+		return
+	}
+
+	if err := msg.Respond(data); err != nil {
+		log.Printf("failed to reply on %s: %v", subject, err)
+	}
+}
+
+// drainSubscribers stops the subscribers from receiving new messages and waits for the ones they already received
+// to be dispatched. Replicas sharing the queue group keep receiving the requests this one no longer takes.
+func (s *Server) drainSubscribers(deadline <-chan struct{}) error {
+	s.mu.Lock()
+	subscribers := s.subscribers
+	s.subscribers = nil
+	s.mu.Unlock()
+
+	for _, sub := range subscribers {
+		if err := sub.Drain(); err != nil {
+			return errors.Wrapf(err, "failed to drain %s", sub.Subject)
+		}
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for _, sub := range subscribers {
+		for sub.IsValid() {
+			select {
+			case <-ticker.C:
+			case <-deadline:
+				return errors.New("subscribers did not drain")
+			}
+		}
+	}
+
+	// Dispatched messages may not have reached the in-flight tracker yet.
+	dispatched := make(chan struct{})
+	go func() {
+		s.dispatched.Wait()
+		close(dispatched)
+	}()
+
+	select {
+	case <-dispatched:
+		return nil
+	case <-deadline:
+		return errors.New("dispatched messages did not finish")
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/Just4Ease/axon/v2"
+	"github.com/Just4Ease/axon/v2/options"
+	"github.com/Just4Ease/axon/v2/systems/jetstream"
+	"github.com/Just4Ease/graphrpc/client"
+	"github.com/stretchr/testify/require"
+)
+
+// wrappedEventStore decorates an axon.EventStore, hiding the NATS connection of the one it wraps.
+type wrappedEventStore struct {
+	axon.EventStore
+}
+
+func TestServeWithoutNATSConnection(t *testing.T) {
+	url := runNATS(t)
+
+	store, err := jetstream.Init(options.Options{ServiceName: "ms-wrapped", Address: url})
+	require.NoError(t, err)
+
+	var handled, inFlight, maxSeen int64
+	s := NewServer(wrappedEventStore{store}, handler.New(replicaExecutableSchema{name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen}), DisableGraphHTTPServer())
+	go func() { _ = s.Serve() }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = s.Shutdown(ctx)
+	})
+
+	clientStore, err := jetstream.Init(options.Options{ServiceName: "ms-wrapped-client", Address: url})
+	require.NoError(t, err)
+	t.Cleanup(clientStore.Close)
+
+	c, err := client.NewClient(clientStore, client.SetRemoteServiceName("ms-wrapped"), client.SetRemoteGraphQLPath("graph"))
+	require.NoError(t, err)
+
+	// Queries are served through EventStore.Reply, which subscribes in the background.
+	res := &struct{ Replica string }{}
+	require.Eventually(t, func() bool {
+		return c.Exec(context.Background(), "", `{ replica }`, res, nil, nil) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "replica", res.Replica)
+
+	_, err = c.Subscribe(context.Background(), "", `subscription { replica }`, nil, nil)
+	require.Equal(t, client.ErrNATSConnectionRequired, err)
+
+	c, err = client.NewClient(clientStore, client.SetRemoteServiceName("ms-wrapped"), client.SetRemoteGraphQLPath("graph"), client.UseNATSConnection(connectNATS(t, url)))
+	require.NoError(t, err)
+
+	_, err = c.Subscribe(context.Background(), "", `subscription { replica }`, nil, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), ErrNATSConnectionRequired.Error())
+}
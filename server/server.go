@@ -15,23 +15,34 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"github.com/99designs/gqlgen/graphql"
 	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
 	"github.com/99designs/gqlgen/graphql/playground"
 	"github.com/Just4Ease/axon/v2"
 	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/graphrpc/internal/metrics"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/gookit/color"
+	"github.com/nats-io/nats.go"
 	"github.com/pkg/errors"
-	"io/ioutil"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"log"
 	"net"
 	"net/http"
+	"os"
 
 	"strings"
 	"sync"
+	"time"
 )
 
 type preRunHook func() error
@@ -41,10 +52,53 @@ type Options struct {
 	serverName       string // default: GraphRPC
 	graphEntrypoint  string // graph entrypoint
 	enablePlayground bool   // disable playground
+	enableHTTPServer bool   // serve the graph over http alongside nats
 	preRunHook       preRunHook
 	postRunHook      postRunHook
 	middlewares      []func(http.Handler) http.Handler
+	natsMiddlewares  []NATSMiddleware
 	address          string // http server address
+
+	tlsCertFile       string         // https certificate file
+	tlsKeyFile        string         // https key file
+	tlsConfig         *tls.Config    // https config
+	tlsClientCAs      *x509.CertPool // CAs accepted for mutual TLS
+	tlsReloadInterval time.Duration  // how often certificate files are checked for changes
+
+	subscriptionKeepAlive time.Duration // how long a subscriber may take to acknowledge a frame
+	shutdownTimeout       time.Duration // how long signal triggered shutdowns wait for in-flight requests
+	shutdownSignals       []os.Signal   // signals that trigger a graceful shutdown
+
+	healthChecks       map[string]HealthCheck // checks that must pass for the server to be ready
+	healthCheckTimeout time.Duration          // how long health checks may run
+
+	metricsRegistry MetricsRegistry // where metrics are registered, nil when metrics are disabled
+
+	tracerProvider trace.TracerProvider          // nil when tracing is disabled
+	propagator     propagation.TextMapPropagator // carries trace context in nats headers
+
+	queueGroup     string // nats queue group shared by replicas
+	maxConcurrency int    // max nats handlers running at once, 0 means unlimited
+	maxQueue       *int   // max messages waiting for a handler slot, nil means unbounded
+
+	executionTimeout  time.Duration            // default execution timeout of operations, 0 means none
+	operationTimeouts map[string]time.Duration // execution timeouts by operation name
+
+	persistedQueryCache graphql.Cache // documents of automatic persisted queries, nil when disabled
+
+	operationManifest         map[string]string   // documents of the registered manifests by hash
+	manifestOperations        map[string]struct{} // names of the operations of the registered manifests
+	enforceOperationAllowlist bool                // reject operations missing from operationManifest
+
+	maxBatchSize int // max operations carried by a single batch
+
+	responseCache        ResponseCache // replies of cacheable queries, nil when disabled
+	responseCacheHeaders []string      // headers telling cached replies apart
+
+	idempotencyStore  IdempotencyStore // replies of mutations by idempotency key, nil when disabled
+	idempotencyWindow time.Duration    // how long replies are replayed
+
+	natsConn *nats.Conn // connection the graph is served on, nil to serve through the axon.EventStore
 }
 
 type Option func(*Options) error
@@ -114,12 +168,48 @@ func DisableGraphPlayground() Option {
 	}
 }
 
+// DisableGraphHTTPServer stops the server from exposing the graph over http, leaving NATS as the only transport.
+func DisableGraphHTTPServer() Option {
+	return func(o *Options) error {
+		o.enableHTTPServer = false
+		return nil
+	}
+}
+
+// SetSubscriptionKeepAlive sets how long a subscription waits for its client to acknowledge a frame before being
+// stopped. Idle subscriptions are sent a keep-alive frame at the same interval, and clients give a subscription up
+// once they heard nothing from it for twice that long.
+func SetSubscriptionKeepAlive(d time.Duration) Option {
+	return func(o *Options) error {
+		if d <= 0 {
+			return errors.New("subscription keep alive must be greater than zero")
+		}
+
+		o.subscriptionKeepAlive = d
+		return nil
+	}
+}
+
 type Server struct {
 	mu               *sync.Mutex
 	axonClient       axon.EventStore // AxonClient
+	nc               *nats.Conn      // nil when served through axonClient
 	opts             *Options        // graph & nats options
 	graphHTTPHandler http.Handler    // graphql/rest handler
+	graphNATSHandler http.Handler    // graphql handler wrapped with middlewares for in-process nats execution
 	graphListener    net.Listener    // graphql listener
+	httpServer       *http.Server    // graphql http server
+	closeSignal      chan struct{}
+	shutdown         bool
+	inFlight         *inFlightTracker      // nats requests currently being handled
+	cancellations    *cancelRegistry       // cancel funcs of in-flight calls
+	subscriptions    *subscriptionRegistry // subscriptions running on this replica
+	ready            bool                  // nats subscribers are mounted
+	metrics          *metrics.RPC          // nil when metrics are disabled
+	tracer           trace.Tracer          // nil when tracing is disabled
+	limiter          *limiter              // nil when concurrency is unlimited
+	subscribers      []*nats.Subscription  // nats subscriptions of the server, drained on shutdown
+	dispatched       sync.WaitGroup        // messages handed to their own goroutine by dispatch
 }
 
 func NewServer(axon axon.EventStore, h *handler.Server, options ...Option) *Server {
@@ -132,6 +222,12 @@ func NewServer(axon axon.EventStore, h *handler.Server, options ...Option) *Serv
 		serverName:       axon.GetServiceName(),
 		graphEntrypoint:  "graph",
 		enablePlayground: true,
+		enableHTTPServer: true,
+
+		subscriptionKeepAlive: 30 * time.Second,
+		tlsReloadInterval:     time.Minute,
+		healthCheckTimeout:    5 * time.Second,
+		maxBatchSize:          protocol.DefaultMaxBatchSize,
 	}
 
 	for _, opt := range options {
@@ -140,11 +236,78 @@ func NewServer(axon axon.EventStore, h *handler.Server, options ...Option) *Serv
 		}
 	}
 
+	var concurrencyLimiter *limiter
+	if opts.maxConcurrency > 0 {
+		maxQueue := -1
+		if opts.maxQueue != nil {
+			maxQueue = *opts.maxQueue
+		}
+		concurrencyLimiter = newLimiter(opts.maxConcurrency, maxQueue)
+	}
+
+	if opts.enforceOperationAllowlist && len(opts.operationManifest) == 0 {
+		log.Fatal("failed to start server: the operation allowlist is enforced but no manifest lists an operation")
+	}
+
+	h.AddTransport(natsTransport{executionTimeout: opts.executionTimeout, operationTimeouts: opts.operationTimeouts})
+
+	if opts.persistedQueryCache != nil {
+		seedPersistedQueries(opts.persistedQueryCache, opts.operationManifest)
+		h.Use(extension.AutomaticPersistedQuery{Cache: opts.persistedQueryCache})
+	}
+
+	h.Use(cacheHint{})
+
+	if opts.responseCache != nil {
+		h.Use(responseCaching{cache: opts.responseCache, keyHeaders: opts.responseCacheHeaders})
+	}
+
+	if opts.enforceOperationAllowlist {
+		h.Use(operationAllowlist{documents: opts.operationManifest})
+	}
+
+	var tracer trace.Tracer
+	if opts.tracerProvider != nil {
+		tracer = opts.tracerProvider.Tracer(tracerName)
+		h.Use(operationTracer{tracer: tracer})
+	}
+
+	var graphNATSHandler http.Handler = h
+	for i := len(opts.middlewares) - 1; i >= 0; i-- {
+		graphNATSHandler = opts.middlewares[i](graphNATSHandler)
+	}
+
+	var rpcMetrics *metrics.RPC
+	if opts.metricsRegistry != nil {
+		var err error
+		if rpcMetrics, err = metrics.NewRPC("server", opts.metricsRegistry); err != nil {
+			log.Fatalf("failed to start server: %v", err)
+		}
+
+		// Operation names are picked by callers: only label the ones the registered manifests expect.
+		if len(opts.manifestOperations) != 0 {
+			names := make([]string, 0, len(opts.manifestOperations))
+			for name := range opts.manifestOperations {
+				names = append(names, name)
+			}
+			rpcMetrics.KnownOperations(names...)
+		}
+	}
+
 	return &Server{
 		mu:               &sync.Mutex{},
 		axonClient:       axon,
+		nc:               opts.natsConn,
 		opts:             opts,
 		graphHTTPHandler: h,
+		graphNATSHandler: graphNATSHandler,
+		closeSignal:      make(chan struct{}),
+		inFlight:         newInFlightTracker(),
+		cancellations:    newCancelRegistry(),
+		subscriptions:    newSubscriptionRegistry(),
+		metrics:          rpcMetrics,
+		tracer:           tracer,
+		limiter:          concurrencyLimiter,
 	}
 }
 
@@ -172,14 +335,42 @@ func (s *Server) Serve() error {
 	color.Yellow.Printf("%s\n", tx)
 	color.Green.Printf("🔥 Service Name          :  %s\n", color.Bold.Sprint(color.Cyan.Sprint(s.axonClient.GetServiceName())))
 
-	var err error
+	for _, mount := range []func() error{
+		s.mountGraphIntrospectionSubscriber,
+		s.mountGraphSubscriber,
+		s.mountGraphSubscriptionSubscriber,
+		s.mountGraphCancellationSubscriber,
+		s.mountHealthSubscriber,
+	} {
+		if err := mount(); err != nil {
+			return errors.Wrap(err, "failed to mount nats subscribers")
+		}
+	}
 
-	if s.graphListener, err = net.Listen("tcp", s.opts.address); err != nil {
+	if err := s.markReady(); err != nil {
 		return err
 	}
 
-	go s.mountGraphIntrospectionSubscriber()
-	go s.mountGraphSubscriber()
+	if s.opts.enableHTTPServer {
+		var err error
+		if s.graphListener, err = net.Listen("tcp", s.opts.address); err != nil {
+			return err
+		}
+
+		tlsConfig, err := s.graphTLSConfig()
+		if err != nil {
+			_ = s.graphListener.Close()
+			return errors.Wrap(err, "failed to configure tls")
+		}
+
+		if tlsConfig != nil {
+			s.graphListener = tls.NewListener(s.graphListener, tlsConfig)
+		}
+	}
+
+	if s.opts.shutdownSignals != nil {
+		go s.handleShutdownSignals()
+	}
 
 	if s.opts.postRunHook != nil {
 		if err := s.opts.postRunHook(s.axonClient); err != nil {
@@ -187,53 +378,44 @@ func (s *Server) Serve() error {
 		}
 	}
 
+	if !s.opts.enableHTTPServer {
+		<-s.closeSignal
+		return nil
+	}
+
 	return s.mountGraphHTTPServer()
 }
 
-func (s *Server) mountGraphSubscriber() {
+func (s *Server) mountGraphSubscriber() error {
 	root := fmt.Sprintf("%s.%s", s.opts.serverName, s.opts.graphEntrypoint)
-	endpoint := fmt.Sprintf("http://%s/%s", s.graphListener.Addr().String(), s.opts.graphEntrypoint)
-	err := s.axonClient.Reply(root, func(mg *messages.Message) (*messages.Message, error) {
-
-		req, err := http.NewRequest("POST", endpoint, bytes.NewReader(mg.Body))
-		if err != nil {
-			return nil, err
-		}
-
-		for k, v := range mg.Header {
-			req.Header.Set(k, v)
-		}
-		req.Header.Set("Content-Type", mg.ContentType.String())
-
-		client := &http.Client{}
-		res, err := client.Do(req)
-		if err != nil {
-			return nil, err
-		}
+	return s.respond(root, s.reply(s.idempotent(s.handleGraphRequest)))
+}
 
-		defer closeResBody(res)
+// handleGraphRequest executes the operation, or batch of operations, carried by a message sent to the graph subject.
+func (s *Server) handleGraphRequest(ctx context.Context, mg *messages.Message) (*messages.Message, error) {
+	if protocol.IsBatch(mg.Body) {
+		return s.executeBatch(ctx, mg)
+	}
 
-		body, err := ioutil.ReadAll(res.Body)
-		if len(body) != 0 {
-			return mg.WithBody(body), nil
-		}
+	res, err := s.execute(ctx, mg.Body, mg.Header)
+	if err != nil {
+		return nil, err
+	}
 
-		if err != nil {
-			return nil, err
-		}
+	// The caller has given up by now, so report the timeout rather than whatever the resolvers managed.
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, ctx.Err()
+	}
 
-		return nil, errors.New("internal server error")
-	})
-	if err != nil {
-		log.Fatal(err)
+	if op := operationInfoFromContext(ctx); op != nil && op.timedOut {
+		return nil, operationTimeoutError(op)
 	}
-	<-make(chan bool)
-}
 
-func closeResBody(res *http.Response) {
-	if err := res.Body.Close(); err != nil {
-		log.Printf("failed to close request body: %v", err)
+	if res.body.Len() != 0 {
+		return replyWithStatus(mg, res.body.Bytes(), res.code), nil
 	}
+
+	return nil, errors.New("internal server error")
 }
 
 func (s *Server) mountGraphHTTPServer() error {
@@ -241,34 +423,56 @@ func (s *Server) mountGraphHTTPServer() error {
 	router.Use(middleware.Recoverer)
 	router.Use(middleware.RequestID)
 	router.Use(middleware.Logger)
-	if s.opts.middlewares != nil && len(s.opts.middlewares) != 0 {
-		router.Use(s.opts.middlewares...)
-	}
 
-	if !s.opts.enablePlayground {
-		router.Get("/", func(writer http.ResponseWriter, request *http.Request) {
-			writer.Header().Set("content-type", "text/html")
-			_, _ = fmt.Fprintf(writer, "<h1 align='center'>%s is running... Please contact administrator for more details</h1>", s.opts.serverName)
-		})
-	}
+	// Probes are answered before the user middlewares, e.g. authentication, get a say.
+	s.mountHealthRoutes(router)
+	s.mountMetricsRoute(router)
 
-	graphEndpoint := fmt.Sprintf("/%s", s.opts.graphEntrypoint)
-	if s.opts.enablePlayground {
-		router.Handle("/", playground.Handler("GraphQL playground", graphEndpoint))
-	}
+	router.Group(func(router chi.Router) {
+		if s.opts.middlewares != nil && len(s.opts.middlewares) != 0 {
+			router.Use(s.opts.middlewares...)
+		}
 
-	router.Handle(graphEndpoint, s.graphHTTPHandler)
+		if !s.opts.enablePlayground {
+			router.Get("/", func(writer http.ResponseWriter, request *http.Request) {
+				writer.Header().Set("content-type", "text/html")
+				_, _ = fmt.Fprintf(writer, "<h1 align='center'>%s is running... Please contact administrator for more details</h1>", s.opts.serverName)
+			})
+		}
+
+		graphEndpoint := fmt.Sprintf("/%s", s.opts.graphEntrypoint)
+		if s.opts.enablePlayground {
+			router.Handle("/", playground.Handler("GraphQL playground", graphEndpoint))
+		}
+
+		router.Handle(graphEndpoint, s.graphHTTPHandler)
+	})
+
+	s.mu.Lock()
+	s.httpServer = &http.Server{Handler: router}
+	s.mu.Unlock()
 
-	// TODO: Serve https with tls.
-	color.Green.Printf("🚀 GraphQL Playground    :  http://%s/\n", s.opts.address)
-	color.Green.Printf("🐙 GraphQL HTTP Endpoint :  http://%s/%s\n", s.opts.address, s.opts.graphEntrypoint)
+	scheme := "http"
+	if s.opts.tlsEnabled() {
+		scheme = "https"
+	}
+
+	color.Green.Printf("🚀 GraphQL Playground    :  %s://%s/\n", scheme, s.opts.address)
+	color.Green.Printf("🐙 GraphQL HTTP Endpoint :  %s://%s/%s\n", scheme, s.opts.address, s.opts.graphEntrypoint)
 	color.Green.Printf("🦾 GraphQL Entry Path    :  %s\n", color.OpUnderscore.Sprint(color.Cyan.Sprintf("/%s", s.opts.graphEntrypoint)))
-	return http.Serve(s.graphListener, router)
+	if err := s.httpServer.Serve(s.graphListener); err != http.ErrServerClosed {
+		return err
+	}
+
+	<-s.closeSignal
+	return nil
 }
 
+// WaitForShutdown gracefully stops the server without a deadline. See Shutdown.
 func (s *Server) WaitForShutdown() {
-	//s.axonClient.Close()
-	_ = s.graphListener.Close()
+	if err := s.Shutdown(context.Background()); err != nil {
+		log.Printf("failed to shutdown gracefully: %v", err)
+	}
 }
 
 const (
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"github.com/Just4Ease/axon/v2"
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/gookit/color"
+	"github.com/pkg/errors"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrServerShuttingDown is returned to NATS requests that arrive after Shutdown has been called.
+var ErrServerShuttingDown = errors.New("server is shutting down")
+
+// inFlightTracker counts the NATS requests currently being handled so Shutdown can wait for them.
+type inFlightTracker struct {
+	mu       sync.Mutex
+	count    int
+	draining bool
+	idle     chan struct{}
+}
+
+func newInFlightTracker() *inFlightTracker {
+	return &inFlightTracker{idle: make(chan struct{})}
+}
+
+// acquire registers a new request, it returns false once the tracker is draining.
+func (t *inFlightTracker) acquire() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.draining {
+		return false
+	}
+
+	t.count++
+	return true
+}
+
+func (t *inFlightTracker) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.count--
+	if t.draining && t.count == 0 {
+		close(t.idle)
+	}
+}
+
+// drain stops new requests from being acquired and returns a channel closed once every in-flight request is released.
+func (t *inFlightTracker) drain() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.draining {
+		t.draining = true
+		if t.count == 0 {
+			close(t.idle)
+		}
+	}
+
+	return t.idle
+}
+
+// trackInFlight wraps a reply handler so Shutdown can wait for it, rejecting messages once the server is draining.
+func (s *Server) trackInFlight(handler axon.ReplyHandler) axon.ReplyHandler {
+	return func(mg *messages.Message) (*messages.Message, error) {
+		if !s.inFlight.acquire() {
+			return nil, ErrServerShuttingDown
+		}
+		defer s.inFlight.release()
+
+		return handler(mg)
+	}
+}
+
+func (s *Server) isShuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shutdown
+}
+
+// HandleShutdownSignals makes Serve call Shutdown when the process receives one of the given signals,
+// waiting at most timeout for in-flight requests. SIGINT and SIGTERM are used when no signal is given.
+func HandleShutdownSignals(timeout time.Duration, signals ...os.Signal) Option {
+	return func(o *Options) error {
+		if timeout <= 0 {
+			return errors.New("shutdown timeout must be greater than zero")
+		}
+
+		if len(signals) == 0 {
+			signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+		}
+
+		o.shutdownTimeout = timeout
+		o.shutdownSignals = signals
+		return nil
+	}
+}
+
+func (s *Server) handleShutdownSignals() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, s.opts.shutdownSignals...)
+	defer signal.Stop(sig)
+
+	select {
+	case received := <-sig:
+		color.Yellow.Printf("🛑 Received %s, shutting down...\n", received)
+	case <-s.closeSignal:
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.shutdownTimeout)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		log.Printf("failed to shutdown gracefully: %v", err)
+	}
+}
+
+// Shutdown gracefully stops the server. It drains its NATS subscriptions, so other replicas take the requests it no
+// longer receives, waits for the in-flight requests to finish, stops the running subscriptions, telling their clients
+// to subscribe again, shuts down the HTTP server and closes the axon EventStore. If ctx expires first, the remaining
+// steps are still carried out and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		return nil
+	}
+	s.shutdown = true
+	s.mu.Unlock()
+
+	var shutdownErr error
+
+	if err := s.drainSubscribers(ctx.Done()); err != nil {
+		shutdownErr = errors.Wrap(err, "failed to drain nats subscriptions")
+	}
+
+	select {
+	case <-s.inFlight.drain():
+	case <-ctx.Done():
+		if shutdownErr == nil {
+			shutdownErr = errors.Wrap(ctx.Err(), "in-flight requests did not finish")
+		}
+	}
+
+	s.subscriptions.removeAll()
+	if err := s.subscriptions.wait(ctx.Done()); err != nil && shutdownErr == nil {
+		shutdownErr = err
+	}
+
+	s.mu.Lock()
+	httpServer := s.httpServer
+	s.mu.Unlock()
+
+	if httpServer != nil {
+		if err := httpServer.Shutdown(ctx); err != nil && shutdownErr == nil {
+			shutdownErr = errors.Wrap(err, "failed to shutdown http server")
+		}
+	} else if s.graphListener != nil {
+		_ = s.graphListener.Close()
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		s.axonClient.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		if shutdownErr == nil {
+			shutdownErr = errors.Wrap(ctx.Err(), "failed to close axon event store")
+		}
+	}
+
+	close(s.closeSignal)
+	return shutdownErr
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdownWaitsForInFlightRequests(t *testing.T) {
+	url := runNATS(t)
+
+	var handled, inFlight, maxSeen int64
+	schema := replicaExecutableSchema{name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen, delay: 200 * time.Millisecond}
+	s := startReplica(t, url, "ms-shutdown", schema)
+	c := newReplicaClient(t, url, "ms-shutdown")
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.Exec(context.Background(), "", `{ replica }`, &struct{ Replica string }{}, nil, nil)
+	}()
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&inFlight) == 1 }, 5*time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+
+	require.NoError(t, <-errs)
+	require.Equal(t, int64(1), handled)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	url := runNATS(t)
+
+	var handled, inFlight, maxSeen int64
+	schema := replicaExecutableSchema{name: "replica", handled: &handled, inFlight: &inFlight, maxSeen: &maxSeen, delay: time.Second}
+	s := startReplica(t, url, "ms-shutdown-deadline", schema)
+	c := newReplicaClient(t, url, "ms-shutdown-deadline")
+
+	go func() {
+		_ = c.Exec(context.Background(), "", `{ replica }`, &struct{ Replica string }{}, nil, nil)
+	}()
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&inFlight) == 1 }, 5*time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.Error(t, s.Shutdown(ctx))
+}
+
+func TestShutdownHandsRequestsToOtherReplicas(t *testing.T) {
+	url := runNATS(t)
+
+	handled := make([]int64, 2)
+	var inFlight, maxSeen int64
+	stopping := startReplica(t, url, "ms-handover", replicaExecutableSchema{name: "stopping", handled: &handled[0], inFlight: &inFlight, maxSeen: &maxSeen, delay: 5 * time.Millisecond})
+	startReplica(t, url, "ms-handover", replicaExecutableSchema{name: "remaining", handled: &handled[1], inFlight: &inFlight, maxSeen: &maxSeen, delay: 5 * time.Millisecond})
+	c := newReplicaClient(t, url, "ms-handover")
+
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				require.NoError(t, c.Exec(context.Background(), "", `{ replica }`, &struct{ Replica string }{}, nil, nil))
+			}
+		}()
+	}
+
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&handled[0]) > 10 }, 5*time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, stopping.Shutdown(ctx))
+
+	// Every request sent from now on is answered by the remaining replica.
+	remaining := atomic.LoadInt64(&handled[1])
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&handled[1]) > remaining+10 }, 5*time.Second, time.Millisecond)
+
+	close(stop)
+	wg.Wait()
+}
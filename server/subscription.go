@@ -0,0 +1,266 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/axon/v2/utils"
+	"github.com/Just4Ease/graphrpc/internal/detached"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+type subscriptionSinkKey struct{}
+
+// subscriptionSink delivers every response produced by a subscription executed by natsTransport to its client.
+// Frames are sent one at a time and each waits for the client to acknowledge it, so a slow client holds the
+// resolver back instead of losing frames, and a vanished one stops the subscription.
+type subscriptionSink struct {
+	mu       sync.Mutex
+	nc       *nats.Conn
+	subject  string
+	timeout  time.Duration
+	lastSent time.Time
+}
+
+func subscriptionSinkFromContext(ctx context.Context) *subscriptionSink {
+	sink, _ := ctx.Value(subscriptionSinkKey{}).(*subscriptionSink)
+	return sink
+}
+
+func (sink *subscriptionSink) send(ctx context.Context, frameType protocol.FrameType, payload []byte) bool {
+	b, err := json.Marshal(&protocol.Frame{Type: frameType, Payload: payload})
+	if err != nil {
+		log.Printf("failed to encode subscription frame: %v", err)
+		return false
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, sink.timeout)
+	defer cancel()
+
+	if _, err := sink.nc.RequestWithContext(ctx, sink.subject, b); err != nil {
+		return false
+	}
+
+	sink.lastSent = time.Now()
+	return true
+}
+
+func (sink *subscriptionSink) sendResponse(ctx context.Context, response *graphql.Response) bool {
+	b, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("failed to encode subscription response: %v", err)
+		return false
+	}
+
+	return sink.send(ctx, protocol.FrameData, b)
+}
+
+func (sink *subscriptionSink) sendError(ctx context.Context, err error) bool {
+	b, _ := json.Marshal(&graphql.Response{Errors: gqlerror.List{{Message: err.Error()}}})
+	return sink.send(ctx, protocol.FrameError, b)
+}
+
+func (sink *subscriptionSink) idleSince() time.Duration {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return time.Since(sink.lastSent)
+}
+
+type subscription struct {
+	id     string
+	sink   *subscriptionSink
+	ctx    context.Context
+	cancel context.CancelFunc
+	stop   *nats.Subscription // FrameStop listener
+}
+
+// subscriptionRegistry tracks the subscriptions running on this replica.
+type subscriptionRegistry struct {
+	mu      sync.Mutex
+	subs    map[string]*subscription
+	running sync.WaitGroup // subscriptions yet to send their final frame
+}
+
+func newSubscriptionRegistry() *subscriptionRegistry {
+	return &subscriptionRegistry{subs: make(map[string]*subscription)}
+}
+
+func (r *subscriptionRegistry) add(sub *subscription) {
+	r.mu.Lock()
+	r.subs[sub.id] = sub
+	r.mu.Unlock()
+}
+
+func (r *subscriptionRegistry) remove(id string) {
+	r.mu.Lock()
+	sub, ok := r.subs[id]
+	delete(r.subs, id)
+	r.mu.Unlock()
+
+	if ok {
+		sub.close()
+	}
+}
+
+func (r *subscriptionRegistry) removeAll() {
+	r.mu.Lock()
+	subs := r.subs
+	r.subs = make(map[string]*subscription)
+	r.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.close()
+	}
+}
+
+// wait waits for the subscriptions to send their final frame.
+func (r *subscriptionRegistry) wait(deadline <-chan struct{}) error {
+	done := make(chan struct{})
+	go func() {
+		r.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-deadline:
+		return errors.New("subscriptions did not send their final frame")
+	}
+}
+
+func (sub *subscription) close() {
+	sub.cancel()
+	_ = sub.stop.Unsubscribe()
+}
+
+func (s *Server) mountGraphSubscriptionSubscriber() error {
+	root := protocol.SubscribeSubject(s.opts.serverName, s.opts.graphEntrypoint)
+	return s.respond(root, s.reply(s.startSubscription))
+}
+
+func (s *Server) startSubscription(ctx context.Context, mg *messages.Message) (*messages.Message, error) {
+	if s.nc == nil {
+		return nil, ErrNATSConnectionRequired
+	}
+
+	frame := &protocol.Frame{}
+	if err := json.Unmarshal(mg.Body, frame); err != nil {
+		return nil, errors.Wrap(err, "failed to decode subscription frame")
+	}
+
+	if frame.Type != protocol.FrameStart {
+		return nil, errors.Errorf("unexpected subscription frame: %s", frame.Type)
+	}
+
+	// Frames are sent as requests to the subject, which must not let callers reach anything but their own inbox.
+	if !strings.HasPrefix(frame.Subject, nats.InboxPrefix) {
+		return nil, errors.Errorf("subscription frames must be sent to an inbox, not %q", frame.Subject)
+	}
+
+	if op := operationInfoFromContext(ctx); op != nil {
+		params := &graphql.RawParams{}
+		if err := json.Unmarshal(frame.Payload, params); err == nil {
+			op.name = params.OperationName
+		}
+		op.operationType = string(ast.Subscription)
+	}
+
+	// The subscription outlives the start request, but keeps the values middlewares put on its context.
+	// Its operation info belongs to the start request, which has been recorded by the time the operation runs.
+	ctx, cancel := context.WithCancel(context.WithValue(detached.Context(ctx), operationInfoKey{}, (*operationInfo)(nil)))
+	sub := &subscription{
+		id: utils.GenerateRandomString(),
+		sink: &subscriptionSink{
+			nc:       s.nc,
+			subject:  frame.Subject,
+			timeout:  s.opts.subscriptionKeepAlive,
+			lastSent: time.Now(),
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	// The ack below is sent on the same connection, so the stop listener is in place before the client learns
+	// the subscription started.
+	var err error
+	if sub.stop, err = s.nc.Subscribe(protocol.StopSubject(frame.Subject), func(*nats.Msg) {
+		s.subscriptions.remove(sub.id)
+	}); err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "failed to listen for subscription stops")
+	}
+	s.subscriptions.add(sub)
+
+	s.subscriptions.running.Add(1)
+	go s.keepSubscriptionAlive(sub)
+	go func() {
+		defer s.subscriptions.running.Done()
+		defer s.subscriptions.remove(sub.id)
+
+		res, err := s.execute(context.WithValue(ctx, subscriptionSinkKey{}, sub.sink), frame.Payload, mg.Header)
+
+		// ctx is over once the subscription is stopped, so the final frame is sent on its own, bounded by the
+		// keep-alive of the sink.
+		final := context.Background()
+		switch {
+		case err != nil:
+			sub.sink.sendError(final, err)
+		case res.body.Len() != 0:
+			// The operation never started streaming, so the body holds the errors that stopped it.
+			sub.sink.send(final, protocol.FrameError, res.body.Bytes())
+		case s.isShuttingDown():
+			// Tell the client to subscribe again, reaching another replica.
+			sub.sink.sendError(final, ErrServerShuttingDown)
+		default:
+			sub.sink.send(final, protocol.FrameComplete, nil)
+		}
+	}()
+
+	ack, err := json.Marshal(&protocol.Frame{
+		Type:      protocol.FrameStart,
+		ID:        sub.id,
+		KeepAlive: s.opts.subscriptionKeepAlive.Milliseconds(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mg.WithBody(ack), nil
+}
+
+// keepSubscriptionAlive sends keep-alive frames while a subscription has nothing to deliver and stops it once its
+// client stops acknowledging them, e.g. because it crashed. Idleness is checked every half interval, so clients hear
+// from the subscription at least once per protocol.KeepAliveWindow.
+func (s *Server) keepSubscriptionAlive(sub *subscription) {
+	ticker := time.NewTicker(s.opts.subscriptionKeepAlive / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if sub.sink.idleSince() < s.opts.subscriptionKeepAlive {
+				continue
+			}
+
+			if !sub.sink.send(sub.ctx, protocol.FrameKeepAlive, nil) {
+				s.subscriptions.remove(sub.id)
+				return
+			}
+		case <-sub.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/Just4Ease/axon/v2/messages"
+	"github.com/Just4Ease/axon/v2/options"
+	"github.com/Just4Ease/axon/v2/systems/jetstream"
+	"github.com/Just4Ease/graphrpc/client"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+var tickerSchema = gqlparser.MustLoadSchema(&ast.Source{Input: `
+	type Query { ping: String! }
+	type Subscription {
+		count(to: Int!): Int!
+		ticks: Int!
+	}
+`})
+
+// tickerExecutableSchema counts up to the given number for count subscriptions, and ticks until stopped for ticks
+// subscriptions, closing stopped once they are torn down.
+type tickerExecutableSchema struct {
+	stopped chan struct{}
+	once    *sync.Once
+}
+
+func (e tickerExecutableSchema) Schema() *ast.Schema {
+	return tickerSchema
+}
+
+func (e tickerExecutableSchema) Complexity(string, string, int, map[string]interface{}) (int, bool) {
+	return 0, false
+}
+
+func (e tickerExecutableSchema) Exec(context.Context) graphql.ResponseHandler {
+	n := 0
+	return func(ctx context.Context) *graphql.Response {
+		field := graphql.GetOperationContext(ctx).Operation.SelectionSet[0].(*ast.Field)
+		n++
+
+		switch field.Name {
+		case "count":
+			to, _ := strconv.Atoi(field.Arguments.ForName("to").Value.Raw)
+			if n > to {
+				return nil
+			}
+			return &graphql.Response{Data: json.RawMessage(fmt.Sprintf(`{"count":%d}`, n))}
+		case "ticks":
+			if n == 1 {
+				go func() {
+					<-ctx.Done()
+					e.once.Do(func() { close(e.stopped) })
+				}()
+			}
+
+			select {
+			case <-time.After(10 * time.Millisecond):
+				return &graphql.Response{Data: json.RawMessage(fmt.Sprintf(`{"ticks":%d}`, n))}
+			case <-ctx.Done():
+				return nil
+			}
+		default:
+			if n > 1 {
+				return nil
+			}
+			return &graphql.Response{Data: json.RawMessage(`{"__typename":"Query"}`)}
+		}
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	url := runNATS(t)
+	schema := tickerExecutableSchema{stopped: make(chan struct{}), once: &sync.Once{}}
+	s := startReplica(t, url, "ms-ticker", schema)
+	c := newReplicaClient(t, url, "ms-ticker")
+
+	t.Run("delivers every frame in order then completes", func(t *testing.T) {
+		payloads, err := c.Subscribe(context.Background(), "", `subscription { count(to: 50) }`, nil, nil)
+		require.NoError(t, err)
+
+		// A slow reader holds the resolver back instead of losing frames.
+		time.Sleep(50 * time.Millisecond)
+
+		counts := make([]int, 0)
+		for payload := range payloads {
+			res := &struct{ Count int }{}
+			require.NoError(t, payload.Decode(res))
+			counts = append(counts, res.Count)
+		}
+
+		require.Len(t, counts, 50)
+		for i, count := range counts {
+			require.Equal(t, i+1, count)
+		}
+	})
+
+	t.Run("reports errors", func(t *testing.T) {
+		payloads, err := c.Subscribe(context.Background(), "", `subscription { missing }`, nil, nil)
+		require.NoError(t, err)
+
+		payload, ok := <-payloads
+		require.True(t, ok)
+		require.Error(t, payload.Err)
+		require.Contains(t, payload.Err.Error(), "missing")
+
+		_, ok = <-payloads
+		require.False(t, ok)
+	})
+
+	t.Run("a stalled subscriber does not hold others back", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		_, err := c.Subscribe(ctx, "", `subscription { count(to: 5) }`, nil, nil)
+		require.NoError(t, err)
+
+		payloads, err := c.Subscribe(context.Background(), "", `subscription { count(to: 5) }`, nil, nil)
+		require.NoError(t, err)
+
+		received := 0
+		for range payloads {
+			received++
+		}
+		require.Equal(t, 5, received)
+	})
+
+	t.Run("stops the resolver when the subscriber goes away", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		payloads, err := c.Subscribe(ctx, "", `subscription { ticks }`, nil, nil)
+		require.NoError(t, err)
+
+		<-payloads
+		<-payloads
+		cancel()
+
+		for range payloads {
+		}
+
+		select {
+		case <-schema.stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the resolver was not stopped")
+		}
+
+		require.Eventually(t, func() bool {
+			s.subscriptions.mu.Lock()
+			defer s.subscriptions.mu.Unlock()
+			return len(s.subscriptions.subs) == 0
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestSubscriptionEndsWithShutdown(t *testing.T) {
+	url := runNATS(t)
+	schema := tickerExecutableSchema{stopped: make(chan struct{}), once: &sync.Once{}}
+	s := startReplica(t, url, "ms-ticker-shutdown", schema)
+	c := newReplicaClient(t, url, "ms-ticker-shutdown")
+
+	payloads, err := c.Subscribe(context.Background(), "", `subscription { ticks }`, nil, nil)
+	require.NoError(t, err)
+	<-payloads
+
+	last := make(chan *client.SubscriptionPayload, 1)
+	go func() {
+		var payload *client.SubscriptionPayload
+		for payload = range payloads {
+		}
+		last <- payload
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+
+	// The client is told to subscribe again rather than left waiting.
+	payload := <-last
+	require.NotNil(t, payload)
+	require.Error(t, payload.Err)
+	require.Contains(t, payload.Err.Error(), ErrServerShuttingDown.Error())
+}
+
+func TestSubscriptionFailsWhenTheReplicaVanishes(t *testing.T) {
+	url := runNATS(t)
+	schema := tickerExecutableSchema{stopped: make(chan struct{}), once: &sync.Once{}}
+	s := startReplica(t, url, "ms-ticker-crash", schema, SetSubscriptionKeepAlive(100*time.Millisecond))
+	c := newReplicaClient(t, url, "ms-ticker-crash")
+
+	payloads, err := c.Subscribe(context.Background(), "", `subscription { ticks }`, nil, nil)
+	require.NoError(t, err)
+	<-payloads
+
+	// The replica drops off NATS without a word to its subscribers.
+	s.nc.Close()
+
+	var last *client.SubscriptionPayload
+	for payload := range payloads {
+		last = payload
+	}
+	require.NotNil(t, last)
+
+	var timeoutErr *client.TimeoutError
+	require.True(t, errors.As(last.Err, &timeoutErr), "unexpected error: %v", last.Err)
+}
+
+func TestSubscriptionFramesOnlyGoToInboxes(t *testing.T) {
+	url := runNATS(t)
+	s := startReplica(t, url, "ms-ticker-inbox", tickerExecutableSchema{stopped: make(chan struct{}), once: &sync.Once{}})
+
+	for _, subject := range []string{"", "ms-users.graph-default", "ms-users._INBOX.abc"} {
+		body, err := json.Marshal(&protocol.Frame{Type: protocol.FrameStart, Subject: subject, Payload: []byte(`{"query":"subscription { ticks }"}`)})
+		require.NoError(t, err)
+
+		_, err = s.startSubscription(context.Background(), messages.NewMessage().WithBody(body))
+		require.Error(t, err, subject)
+	}
+
+	s.subscriptions.mu.Lock()
+	defer s.subscriptions.mu.Unlock()
+	require.Empty(t, s.subscriptions.subs)
+}
+
+func TestSubscriptionRunsClientInterceptors(t *testing.T) {
+	url := runNATS(t)
+
+	requireAuthorization := func(next NATSHandler) NATSHandler {
+		return func(ctx context.Context, mg *messages.Message) (*messages.Message, error) {
+			if mg.Header["Authorization"] == "" {
+				return nil, errors.New("unauthorized")
+			}
+			return next(ctx, mg)
+		}
+	}
+	startReplica(t, url, "ms-ticker-auth", tickerExecutableSchema{stopped: make(chan struct{}), once: &sync.Once{}}, UseNATSMiddlewares(requireAuthorization))
+
+	store, err := jetstream.Init(options.Options{ServiceName: "ms-ticker-auth-client", Address: url})
+	require.NoError(t, err)
+	t.Cleanup(store.Close)
+
+	authenticate := func(ctx context.Context, operationName, query string, respData interface{}, vars map[string]interface{}, headers client.Header, next client.Invoker) error {
+		headers["Authorization"] = "Bearer token"
+		return next(ctx, operationName, query, respData, vars, headers)
+	}
+	c, err := client.NewClient(store, client.SetRemoteServiceName("ms-ticker-auth"), client.SetRemoteGraphQLPath("graph"),
+		client.UseNATSConnection(connectNATS(t, url)), client.UseInterceptors(authenticate))
+	require.NoError(t, err)
+
+	payloads, err := c.Subscribe(context.Background(), "", `subscription { count(to: 3) }`, nil, nil)
+	require.NoError(t, err)
+
+	received := 0
+	for payload := range payloads {
+		require.NoError(t, payload.Err)
+		received++
+	}
+	require.Equal(t, 3, received)
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/Just4Ease/graphrpc/internal/protocol"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeoutDirectiveSDL declares the @timeout directive. Add it to the schema to give root fields their own execution
+// timeout, e.g. `report: Report! @timeout(ms: 30000)`. The directive has no runtime behaviour, so either mark it
+// skip_runtime in gqlgen.yml or wire TimeoutDirective into the generated DirectiveRoot.
+const TimeoutDirectiveSDL = `directive @timeout(ms: Int!) on FIELD_DEFINITION`
+
+// TimeoutDirective is the resolver-side implementation of @timeout, which only passes through: the timeout is applied
+// by the server before execution starts.
+func TimeoutDirective(ctx context.Context, _ interface{}, next graphql.Resolver, _ int) (interface{}, error) {
+	return next(ctx)
+}
+
+// SetExecutionTimeout bounds how long any operation arriving over NATS may run. Callers that send a shorter deadline
+// keep theirs.
+func SetExecutionTimeout(d time.Duration) Option {
+	return func(o *Options) error {
+		if d <= 0 {
+			return errors.New("execution timeout must be greater than zero")
+		}
+
+		o.executionTimeout = d
+		return nil
+	}
+}
+
+// SetOperationTimeout overrides the execution timeout of the named operation. It takes precedence over @timeout.
+func SetOperationTimeout(operationName string, d time.Duration) Option {
+	return func(o *Options) error {
+		if strings.TrimSpace(operationName) == empty {
+			return errors.New("operation name is required")
+		}
+
+		if d <= 0 {
+			return errors.New("operation timeout must be greater than zero")
+		}
+
+		if o.operationTimeouts == nil {
+			o.operationTimeouts = make(map[string]time.Duration)
+		}
+		o.operationTimeouts[operationName] = d
+		return nil
+	}
+}
+
+// operationTimeout returns the timeout of an operation: its override by name, else the largest @timeout among its
+// root fields, else the execution timeout. Zero means the operation is only bounded by the caller's deadline.
+func (t natsTransport) operationTimeout(rc *graphql.OperationContext) time.Duration {
+	if d, ok := t.operationTimeouts[rc.OperationName]; ok {
+		return d
+	}
+
+	if rc.Operation == nil {
+		return t.executionTimeout
+	}
+
+	var directiveTimeout time.Duration
+	for _, field := range graphql.CollectFields(rc, rc.Operation.SelectionSet, nil) {
+		if field.Definition == nil {
+			continue
+		}
+
+		directive := field.Definition.Directives.ForName("timeout")
+		if directive == nil {
+			continue
+		}
+
+		arg := directive.Arguments.ForName("ms")
+		if arg == nil || arg.Value == nil {
+			continue
+		}
+
+		if ms, err := strconv.ParseInt(arg.Value.Raw, 10, 64); err == nil && time.Duration(ms)*time.Millisecond > directiveTimeout {
+			directiveTimeout = time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	if directiveTimeout > 0 {
+		return directiveTimeout
+	}
+
+	return t.executionTimeout
+}
+
+// execution is an operation dispatched to the resolvers, which run in the background so a resolver ignoring its
+// context cannot hold the NATS handler past the deadline.
+type execution struct {
+	done     chan struct{}
+	response *graphql.Response
+}
+
+func startExecution(ctx context.Context, responses graphql.ResponseHandler) *execution {
+	e := &execution{done: make(chan struct{})}
+	go func() {
+		defer close(e.done)
+		e.response = responses(ctx)
+	}()
+	return e
+}
+
+// await returns the response of the operation, or nil when ctx is done first.
+func (e *execution) await(ctx context.Context) *graphql.Response {
+	select {
+	case <-e.done:
+		return e.response
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// wait returns the response of the operation once its resolvers returned, however long the caller waited.
+func (e *execution) wait() *graphql.Response {
+	<-e.done
+	return e.response
+}
+
+// operationTimeoutError is the error replied when an operation ran past its timeout.
+func operationTimeoutError(op *operationInfo) error {
+	name := op.name
+	if name == empty {
+		name = "anonymous operation"
+	}
+
+	return &protocol.Error{
+		Kind:    protocol.ErrorKindTimeout,
+		Status:  http.StatusGatewayTimeout,
+		Message: fmt.Sprintf("%s timed out after %s", name, op.timeout),
+	}
+}
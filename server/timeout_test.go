@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Just4Ease/graphrpc/client"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestOperationTimeout(t *testing.T) {
+	url := runNATS(t)
+
+	var handled, inFlight, maxSeen int64
+	schema := replicaExecutableSchema{
+		schema:   gqlparser.MustLoadSchema(&ast.Source{Input: TimeoutDirectiveSDL + "\ntype Query { replica: String! @timeout(ms: 1000) }"}),
+		name:     "replica",
+		handled:  &handled,
+		inFlight: &inFlight,
+		maxSeen:  &maxSeen,
+		delay:    200 * time.Millisecond,
+	}
+	startReplica(t, url, "ms-timeouts", schema, SetExecutionTimeout(50*time.Millisecond), SetOperationTimeout("Fast", 50*time.Millisecond))
+	c := newReplicaClient(t, url, "ms-timeouts")
+
+	t.Run("directive", func(t *testing.T) {
+		res := &struct{ Replica string }{}
+		require.NoError(t, c.Exec(context.Background(), "Slow", `query Slow { replica }`, res, nil, nil))
+		require.Equal(t, "replica", res.Replica)
+	})
+
+	t.Run("operation override", func(t *testing.T) {
+		start := time.Now()
+		err := c.Exec(context.Background(), "Fast", `query Fast { replica }`, &struct{ Replica string }{}, nil, nil)
+
+		var timeoutErr *client.TimeoutError
+		require.True(t, errors.As(err, &timeoutErr), "unexpected error: %v", err)
+		require.Contains(t, timeoutErr.Message, "Fast timed out after 50ms")
+		require.Less(t, int64(time.Since(start)), int64(200*time.Millisecond))
+	})
+}
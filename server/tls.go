@@ -0,0 +1,191 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SetTLSCertificate serves the graph over https using the given certificate and key files.
+// The files are watched and the certificate is reloaded whenever either of them changes.
+func SetTLSCertificate(certFile, keyFile string) Option {
+	return func(o *Options) error {
+		if strings.TrimSpace(certFile) == empty || strings.TrimSpace(keyFile) == empty {
+			return errors.New("tls certificate and key files are required")
+		}
+
+		o.tlsCertFile = certFile
+		o.tlsKeyFile = keyFile
+		return nil
+	}
+}
+
+// SetTLSConfig serves the graph over https using the given tls.Config. Certificates set with SetTLSCertificate
+// take precedence over the ones in the config.
+func SetTLSConfig(config *tls.Config) Option {
+	return func(o *Options) error {
+		if config == nil {
+			return errors.New("cannot use nil as tls config")
+		}
+
+		o.tlsConfig = config
+		return nil
+	}
+}
+
+// RequireClientCertificates enables mutual TLS, only accepting clients presenting a certificate signed by one of
+// the given CAs.
+func RequireClientCertificates(clientCAs *x509.CertPool) Option {
+	return func(o *Options) error {
+		if clientCAs == nil {
+			return errors.New("cannot use nil as client CA pool")
+		}
+
+		o.tlsClientCAs = clientCAs
+		return nil
+	}
+}
+
+// SetTLSReloadInterval sets how often the files given to SetTLSCertificate are checked for changes.
+func SetTLSReloadInterval(d time.Duration) Option {
+	return func(o *Options) error {
+		if d <= 0 {
+			return errors.New("tls reload interval must be greater than zero")
+		}
+
+		o.tlsReloadInterval = d
+		return nil
+	}
+}
+
+func (o *Options) tlsEnabled() bool {
+	return o.tlsConfig != nil || o.tlsCertFile != empty
+}
+
+// graphTLSConfig builds the tls.Config of the graph http server, or nil when TLS is not enabled.
+func (s *Server) graphTLSConfig() (*tls.Config, error) {
+	if !s.opts.tlsEnabled() {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.opts.tlsConfig != nil {
+		config = s.opts.tlsConfig.Clone()
+	}
+
+	if s.opts.tlsCertFile != empty {
+		reloader, err := newCertReloader(s.opts.tlsCertFile, s.opts.tlsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		go reloader.watch(s.opts.tlsReloadInterval, s.closeSignal)
+		config.Certificates = nil
+		config.GetCertificate = reloader.getCertificate
+	}
+
+	if s.opts.tlsClientCAs != nil {
+		config.ClientCAs = s.opts.tlsClientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// certReloader serves a certificate loaded from disk, reloading it when its files change.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+func (r *certReloader) reload() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to load tls certificate")
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+	return nil
+}
+
+// lastModified returns the latest modification time of the certificate and key files.
+func (r *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, errors.Wrap(err, "failed to stat tls certificate")
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+func (r *certReloader) watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			modTime, err := r.lastModified()
+			if err != nil {
+				log.Printf("failed to check tls certificate for changes: %v", err)
+				continue
+			}
+
+			r.mu.RLock()
+			changed := modTime.After(r.modTime)
+			r.mu.RUnlock()
+
+			if !changed {
+				continue
+			}
+
+			// Keep serving the previous certificate if the new one is invalid, e.g. only half written.
+			if err := r.reload(); err != nil {
+				log.Printf("failed to reload tls certificate: %v", err)
+				continue
+			}
+			log.Printf("reloaded tls certificate %s", r.certFile)
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testCA signs the certificates of a test.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "graphrpc test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns the PEM encoded certificate and key of a leaf signed by ca, valid for localhost.
+func (ca *testCA) issue(t *testing.T, commonName string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeKeyPair writes a certificate and key to dir, dated modTime.
+func writeKeyPair(t *testing.T, dir string, cert, key []byte, modTime time.Time) (string, string) {
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	require.NoError(t, ioutil.WriteFile(certFile, cert, 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, key, 0600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+	return certFile, keyFile
+}
+
+// startHTTPSServer serves the viewer graph over https and returns its address.
+func startHTTPSServer(t *testing.T, opts ...Option) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	s := newViewerServer(t, runNATS(t), append(opts, SetGraphHTTPServerAddress(address), DisableGraphPlayground())...)
+	go func() { _ = s.Serve() }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = s.Shutdown(ctx)
+	})
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			return false
+		}
+		return conn.Close() == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	return address
+}
+
+func httpsClient(config *tls.Config) *http.Client {
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}, Timeout: 5 * time.Second}
+}
+
+func TestHTTPSListener(t *testing.T) {
+	ca := newTestCA(t)
+	cert, key := ca.issue(t, "server")
+	certFile, keyFile := writeKeyPair(t, t.TempDir(), cert, key, time.Now())
+
+	address := startHTTPSServer(t, SetTLSCertificate(certFile, keyFile))
+
+	res, err := httpsClient(&tls.Config{RootCAs: ca.pool}).Post(fmt.Sprintf("https://%s/graph", address), "application/json", bytes.NewBufferString(`{"query":"{ viewer }"}`))
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.NotNil(t, res.TLS)
+
+	// Plain http is turned away.
+	res, err = (&http.Client{Timeout: 5 * time.Second}).Get(fmt.Sprintf("http://%s/healthz", address))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestRequireClientCertificates(t *testing.T) {
+	ca := newTestCA(t)
+	cert, key := ca.issue(t, "server")
+	certFile, keyFile := writeKeyPair(t, t.TempDir(), cert, key, time.Now())
+
+	address := startHTTPSServer(t, SetTLSCertificate(certFile, keyFile), RequireClientCertificates(ca.pool))
+	url := fmt.Sprintf("https://%s/healthz", address)
+
+	_, err := httpsClient(&tls.Config{RootCAs: ca.pool}).Get(url)
+	require.Error(t, err, "a client without a certificate was let in")
+
+	clientCert, clientKey := ca.issue(t, "client")
+	pair, err := tls.X509KeyPair(clientCert, clientKey)
+	require.NoError(t, err)
+
+	res, err := httpsClient(&tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{pair}}).Get(url)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestCertReloader(t *testing.T) {
+	t.Parallel()
+	ca := newTestCA(t)
+	dir := t.TempDir()
+
+	cert, key := ca.issue(t, "first")
+	modTime := time.Now().Add(-time.Minute)
+	certFile, keyFile := writeKeyPair(t, dir, cert, key, modTime)
+
+	r, err := newCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go r.watch(10*time.Millisecond, stop)
+
+	served := func() string {
+		cert, err := r.getCertificate(nil)
+		require.NoError(t, err)
+
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		require.NoError(t, err)
+		return leaf.Subject.CommonName
+	}
+	require.Equal(t, "first", served())
+
+	cert, key = ca.issue(t, "second")
+	writeKeyPair(t, dir, cert, key, modTime.Add(time.Second))
+	require.Eventually(t, func() bool { return served() == "second" }, 5*time.Second, 10*time.Millisecond)
+
+	// A pair that does not load, e.g. only half written, leaves the previous certificate in place.
+	writeKeyPair(t, dir, cert[:len(cert)/2], key, modTime.Add(2*time.Second))
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, "second", served())
+
+	cert, key = ca.issue(t, "third")
+	writeKeyPair(t, dir, cert, key, modTime.Add(3*time.Second))
+	require.Eventually(t, func() bool { return served() == "third" }, 5*time.Second, 10*time.Millisecond)
+}
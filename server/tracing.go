@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/Just4Ease/axon/v2/messages"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/Just4Ease/graphrpc/server"
+
+// EnableTracing continues the trace of every call arriving over NATS with a server span, and wraps the execution of
+// every GraphQL operation in a span of its own. The global tracer provider is used when provider is nil and W3C trace
+// context when propagator is nil.
+func EnableTracing(provider trace.TracerProvider, propagator propagation.TextMapPropagator) Option {
+	return func(o *Options) error {
+		if provider == nil {
+			provider = otel.GetTracerProvider()
+		}
+
+		if propagator == nil {
+			propagator = propagation.TraceContext{}
+		}
+
+		o.tracerProvider = provider
+		o.propagator = propagator
+		return nil
+	}
+}
+
+// startSpan extracts the trace context sent by the caller and starts the server span of a message.
+func (s *Server) startSpan(ctx context.Context, mg *messages.Message) (context.Context, trace.Span) {
+	if s.tracer == nil {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+
+	ctx = s.opts.propagator.Extract(ctx, propagation.MapCarrier(mg.Header))
+	return s.tracer.Start(ctx, mg.Subject,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String("graphrpc"),
+			semconv.RPCServiceKey.String(s.opts.serverName),
+			semconv.MessagingDestinationKey.String(mg.Subject),
+		),
+	)
+}
+
+// endSpan names the server span after the operation it executed and records how it ended.
+func (s *Server) endSpan(span trace.Span, op *operationInfo, err error) {
+	if op.operationType != empty {
+		span.SetName(fmt.Sprintf("%s/%s", s.opts.serverName, op.name))
+		span.SetAttributes(
+			semconv.RPCMethodKey.String(op.name),
+			attribute.String("graphql.operation.name", op.name),
+			attribute.String("graphql.operation.type", op.operationType),
+		)
+	}
+
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case op.graphErrors:
+		span.SetStatus(codes.Error, "graphql errors")
+	}
+
+	span.End()
+}
+
+// operationTracer is a gqlgen extension wrapping the execution of every GraphQL operation in a span.
+type operationTracer struct {
+	tracer trace.Tracer
+}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.ResponseInterceptor
+} = operationTracer{}
+
+func (t operationTracer) ExtensionName() string {
+	return "GraphRPCTracing"
+}
+
+func (t operationTracer) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (t operationTracer) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	if !graphql.HasOperationContext(ctx) {
+		return next(ctx)
+	}
+
+	rc := graphql.GetOperationContext(ctx)
+	operationType := "unknown"
+	if rc.Operation != nil {
+		operationType = string(rc.Operation.Operation)
+	}
+
+	ctx, span := t.tracer.Start(ctx, fmt.Sprintf("graphql.%s %s", operationType, rc.OperationName),
+		trace.WithAttributes(
+			attribute.String("graphql.operation.name", rc.OperationName),
+			attribute.String("graphql.operation.type", operationType),
+		),
+	)
+	defer span.End()
+
+	response := next(ctx)
+	if response != nil && len(response.Errors) != 0 {
+		span.SetStatus(codes.Error, response.Errors.Error())
+	}
+
+	return response
+}
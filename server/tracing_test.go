@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/Just4Ease/axon/v2/options"
+	"github.com/Just4Ease/axon/v2/systems/jetstream"
+	"github.com/Just4Ease/graphrpc/client"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/ast"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedExecutableSchema answers every query and hands over the span context its resolver ran in.
+type tracedExecutableSchema struct {
+	resolved chan<- trace.SpanContext
+}
+
+func (tracedExecutableSchema) Schema() *ast.Schema {
+	return replicaSchema
+}
+
+func (tracedExecutableSchema) Complexity(string, string, int, map[string]interface{}) (int, bool) {
+	return 0, false
+}
+
+func (e tracedExecutableSchema) Exec(context.Context) graphql.ResponseHandler {
+	done := false
+	return func(ctx context.Context) *graphql.Response {
+		if done {
+			return nil
+		}
+		done = true
+
+		e.resolved <- trace.SpanContextFromContext(ctx)
+		return &graphql.Response{Data: json.RawMessage(`{"replica":"traced"}`)}
+	}
+}
+
+func TestTracingAcrossNATS(t *testing.T) {
+	url := runNATS(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	resolved := make(chan trace.SpanContext, 1)
+	startReplica(t, url, "ms-traced", tracedExecutableSchema{resolved: resolved}, EnableTracing(provider, nil))
+
+	store, err := jetstream.Init(options.Options{ServiceName: "ms-traced-client", Address: url})
+	require.NoError(t, err)
+	t.Cleanup(store.Close)
+
+	c, err := client.NewClient(store, client.SetRemoteServiceName("ms-traced"), client.SetRemoteGraphQLPath("graph"), client.EnableTracing(provider, nil))
+	require.NoError(t, err)
+
+	require.NoError(t, c.Exec(context.Background(), "GetReplica", `query GetReplica { replica }`, &struct{ Replica string }{}, nil, nil))
+	require.Eventually(t, func() bool { return len(recorder.Ended()) == 3 }, 5*time.Second, 10*time.Millisecond)
+
+	spans := make(map[trace.SpanKind]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.SpanKind()] = span
+	}
+	clientSpan, serverSpan, operationSpan := spans[trace.SpanKindClient], spans[trace.SpanKindServer], spans[trace.SpanKindInternal]
+	require.NotNil(t, clientSpan)
+	require.NotNil(t, serverSpan)
+	require.NotNil(t, operationSpan)
+
+	// The server continues the trace of the client, sent in the message headers.
+	require.Equal(t, "ms-traced/GetReplica", serverSpan.Name())
+	require.Equal(t, clientSpan.SpanContext().TraceID(), serverSpan.SpanContext().TraceID())
+	require.Equal(t, clientSpan.SpanContext().SpanID(), serverSpan.Parent().SpanID())
+	require.True(t, serverSpan.Parent().IsRemote())
+
+	require.Equal(t, "graphql.query GetReplica", operationSpan.Name())
+	require.Equal(t, serverSpan.SpanContext().SpanID(), operationSpan.Parent().SpanID())
+
+	// Resolvers run within the operation span.
+	resolverSpan := <-resolved
+	require.Equal(t, operationSpan.SpanContext().TraceID(), resolverSpan.TraceID())
+	require.Equal(t, operationSpan.SpanContext().SpanID(), resolverSpan.SpanID())
+}
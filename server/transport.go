@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"io"
+	"net/http"
+	"time"
+)
+
+// natsMethod is the pseudo HTTP method used for requests that arrive over NATS.
+// No stock gqlgen transport supports it, so natsTransport is always the one picked by the handler.Server.
+const natsMethod = "NATS"
+
+// natsRequestKey marks the context of requests built by Server.execute, so an HTTP client sending a request with
+// the NATS method cannot reach natsTransport.
+type natsRequestKey struct{}
+
+// natsHeaderKey holds the headers of the NATS message a request built by Server.execute was made from.
+type natsHeaderKey struct{}
+
+// natsTransport is a graphql.Transport that executes GraphRPC requests directly against the gqlgen executor.
+type natsTransport struct {
+	executionTimeout  time.Duration
+	operationTimeouts map[string]time.Duration
+}
+
+var _ graphql.Transport = natsTransport{}
+
+func (t natsTransport) Supports(r *http.Request) bool {
+	isNATS, _ := r.Context().Value(natsRequestKey{}).(bool)
+	return r.Method == natsMethod && isNATS
+}
+
+func (t natsTransport) Do(w http.ResponseWriter, r *http.Request, exec graphql.GraphExecutor) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var params *graphql.RawParams
+	start := graphql.Now()
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&params); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeGraphResponse(w, &graphql.Response{Errors: gqlerror.List{{Message: "json body could not be decoded: " + err.Error()}}})
+		return
+	}
+	params.ReadTime = graphql.TraceTiming{
+		Start: start,
+		End:   graphql.Now(),
+	}
+
+	rc, errs := exec.CreateOperationContext(r.Context(), params)
+	op := operationInfoFromContext(r.Context())
+	if op != nil {
+		op.name = params.OperationName
+		op.graphErrors = errs != nil
+		if rc != nil && rc.Operation != nil {
+			op.name = rc.OperationName
+			op.operationType = string(rc.Operation.Operation)
+		}
+	}
+
+	if errs != nil {
+		w.WriteHeader(statusFor(errs))
+		writeGraphResponse(w, exec.DispatchError(graphql.WithOperationContext(r.Context(), rc), errs))
+		return
+	}
+
+	// Subscriptions keep producing responses until the resolver completes or the subscription is stopped.
+	if sink := subscriptionSinkFromContext(r.Context()); sink != nil {
+		responses, ctx := exec.DispatchOperation(r.Context(), rc)
+		for {
+			response := responses(ctx)
+			if response == nil || !sink.sendResponse(ctx, response) {
+				return
+			}
+		}
+	}
+
+	ctx := r.Context()
+	if timeout := t.operationTimeout(rc); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		if op != nil {
+			op.timeout = timeout
+		}
+	}
+
+	responses, dispatchCtx := exec.DispatchOperation(ctx, rc)
+	// Operation interceptors answering on their own, e.g. the response cache, leave no context behind.
+	if dispatchCtx != nil {
+		ctx = dispatchCtx
+	}
+
+	execution := startExecution(ctx, responses)
+	if op != nil {
+		op.execution = execution
+	}
+
+	response := execution.await(ctx)
+	if response == nil {
+		if op != nil {
+			op.timedOut = ctx.Err() == context.DeadlineExceeded
+		}
+
+		w.WriteHeader(http.StatusGatewayTimeout)
+		writeGraphResponse(w, &graphql.Response{Errors: gqlerror.List{{Message: ctx.Err().Error()}}})
+		return
+	}
+
+	if op != nil && len(response.Errors) != 0 {
+		op.graphErrors = true
+	}
+
+	writeGraphResponse(w, response)
+}
+
+func statusFor(errs gqlerror.List) int {
+	switch errcode.GetErrorKind(errs) {
+	case errcode.KindProtocol:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusOK
+	}
+}
+
+func writeGraphResponse(w io.Writer, response *graphql.Response) {
+	b, err := json.Marshal(response)
+	if err != nil {
+		panic(err)
+	}
+	_, _ = w.Write(b)
+}
+
+// responseRecorder is an in-memory http.ResponseWriter used to capture the output of the graph handler.
+type responseRecorder struct {
+	header http.Header
+	code   int
+	body   *bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{
+		header: make(http.Header),
+		code:   http.StatusOK,
+		body:   new(bytes.Buffer),
+	}
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	r.code = code
+}
+
+// execute runs a GraphQL request body through the middleware chain and the graph handler without leaving the process.
+func (s *Server) execute(ctx context.Context, body []byte, header map[string]string) (*responseRecorder, error) {
+	ctx = context.WithValue(context.WithValue(ctx, natsRequestKey{}, true), natsHeaderKey{}, header)
+	req, err := http.NewRequestWithContext(ctx, natsMethod, fmt.Sprintf("/%s", s.opts.graphEntrypoint), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	w := newResponseRecorder()
+	s.graphNATSHandler.ServeHTTP(w, req)
+	return w, nil
+}
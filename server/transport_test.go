@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/Just4Ease/axon/v2/options"
+	"github.com/Just4Ease/axon/v2/systems/jetstream"
+	"github.com/Just4Ease/graphrpc/client"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+type viewerKey struct{}
+
+// viewerExecutableSchema answers with the viewer an HTTP middleware stored in the context.
+type viewerExecutableSchema struct{}
+
+func (viewerExecutableSchema) Schema() *ast.Schema {
+	return gqlparser.MustLoadSchema(&ast.Source{Input: `type Query { viewer: String! }`})
+}
+
+func (viewerExecutableSchema) Complexity(string, string, int, map[string]interface{}) (int, bool) {
+	return 0, false
+}
+
+func (viewerExecutableSchema) Exec(context.Context) graphql.ResponseHandler {
+	done := false
+	return func(ctx context.Context) *graphql.Response {
+		if done {
+			return nil
+		}
+		done = true
+
+		viewer, _ := ctx.Value(viewerKey{}).(string)
+		return &graphql.Response{Data: json.RawMessage(fmt.Sprintf(`{"viewer":%q}`, viewer))}
+	}
+}
+
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		viewer := r.Header.Get("Authorization")
+		if viewer == "" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), viewerKey{}, viewer)))
+	})
+}
+
+func newViewerServer(t *testing.T, url string, opts ...Option) *Server {
+	store, err := jetstream.Init(options.Options{ServiceName: "ms-viewer", Address: url})
+	require.NoError(t, err)
+
+	h := handler.New(viewerExecutableSchema{})
+	h.AddTransport(transport.POST{})
+	return NewServer(store, h, append([]Option{UseNATSConnection(connectNATS(t, url))}, opts...)...)
+}
+
+func TestNATSTransportRejectsHTTPRequests(t *testing.T) {
+	s := newViewerServer(t, runNATS(t))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(natsMethod, "/graph", bytes.NewBufferString(`{"query":"{ viewer }"}`))
+	s.graphHTTPHandler.ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "transport not supported")
+}
+
+func TestNATSExecutionMatchesHTTP(t *testing.T) {
+	url := runNATS(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	s := newViewerServer(t, url, SetGraphHTTPServerAddress(address), DisableGraphPlayground(), UseMiddlewares(authenticate))
+	go func() { _ = s.Serve() }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = s.Shutdown(ctx)
+	})
+	require.Eventually(t, s.isReady, 5*time.Second, 10*time.Millisecond)
+
+	overHTTP := func(authorization string) (int, string) {
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/graph", address), bytes.NewBufferString(`{"query":"{ viewer }"}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, string(body)
+	}
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			return false
+		}
+		return conn.Close() == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	c := newReplicaClient(t, url, "ms-viewer")
+
+	status, body := overHTTP("alice")
+	require.Equal(t, http.StatusOK, status)
+	require.JSONEq(t, `{"data":{"viewer":"alice"}}`, body)
+
+	res := &struct{ Viewer string }{}
+	require.NoError(t, c.Exec(context.Background(), "", `{ viewer }`, res, nil, client.Header{"Authorization": "alice"}))
+	require.Equal(t, "alice", res.Viewer)
+
+	// Both transports are turned away by the same middleware.
+	status, _ = overHTTP("")
+	require.Equal(t, http.StatusUnauthorized, status)
+
+	err = c.Exec(context.Background(), "", `{ viewer }`, &struct{ Viewer string }{}, nil, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "401")
+}